@@ -0,0 +1,310 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/auto"
+	"github.com/spf13/cobra"
+)
+
+// ControlPlaneAcl limits access to the LKE control plane, set in the platform
+// config under controlPlaneAcl.
+type ControlPlaneAcl struct {
+	Enabled bool     `yaml:"enabled,omitempty" json:"enabled"`
+	Ipv4    []string `yaml:"ipv4,omitempty"    json:"ipv4"`
+	Ipv6    []string `yaml:"ipv6,omitempty"    json:"ipv6"`
+}
+
+// myIpURLs return the public IPv4 and IPv6 address the request comes from as
+// plain text. The IPv6 one fails on networks without IPv6.
+var myIpURLs = []string{"https://api.ipify.org", "https://api6.ipify.org"}
+
+var aclMyIp bool
+
+var aclCmd = &cobra.Command{
+	Use:   "acl",
+	Short: "List and update the control plane ACL of the LKE cluster",
+}
+
+var aclListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the addresses allowed to reach the control plane",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := context.Background()
+		stk := infraStack(ctx)
+		acl := stackControlPlaneAcl(ctx, initLocalStack(ctx, stk))
+
+		if !acl.Enabled {
+			logger.Info("control plane acl is disabled: the control plane is reachable from anywhere")
+
+			return
+		}
+
+		logger.Info("header:control plane acl")
+
+		for _, i := range slices.Concat(acl.Ipv4, acl.Ipv6) {
+			logger.Info("line:" + i)
+		}
+
+		fmt.Println() //nolint:forbidigo
+	},
+}
+
+var aclAddCmd = &cobra.Command{
+	Use:   "add [cidr]...",
+	Short: "Allow CIDRs or IPs to reach the control plane and enable the ACL",
+	PreRun: func(cmd *cobra.Command, args []string) {
+		if len(args) == 0 && !aclMyIp {
+			logger.Error("acl add: a cidr or --my-ip is required")
+			os.Exit(1)
+		}
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := context.Background()
+
+		if aclMyIp {
+			ips, err := myIps(ctx)
+			if err != nil {
+				logger.Error("acl add: " + err.Error())
+
+				return
+			}
+
+			logger.Info("current public ip: " + strings.Join(ips, ", "))
+			args = append(args, ips...)
+		}
+
+		if err := updateControlPlaneAcl(ctx, args, nil); err != nil {
+			logger.Error("acl add: " + err.Error())
+		}
+	},
+}
+
+var aclRemoveCmd = &cobra.Command{
+	Use:   "remove <cidr>...",
+	Short: "Remove CIDRs or IPs from the control plane ACL",
+	Args:  cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := updateControlPlaneAcl(context.Background(), nil, args); err != nil {
+			logger.Error("acl remove: " + err.Error())
+		}
+	},
+}
+
+func init() {
+	// required flags
+	aclCmd.PersistentFlags().StringVarP(&platform.Name, "name", "n", "", "APL instance name (required)")
+	aclCmd.MarkPersistentFlagRequired("name") //nolint:errcheck
+	// optional flags
+	aclAddCmd.Flags().BoolVarP(&aclMyIp, "my-ip", "", false, "Add the current public IPv4 and IPv6 addresses")
+
+	aclCmd.AddCommand(aclAddCmd, aclListCmd, aclRemoveCmd)
+}
+
+// infraStack returns the infra microstack of the platform.
+func infraStack(ctx context.Context) *MicroStack {
+	stk := &MicroStack{Name: "infra", PostRun: []string{"addNodeBalancerId"}}
+	stk.Path = filepath.Join(paths.Projects, platform.Name, "cmd", stk.Name)
+	stk.GetFullName(ctx)
+
+	return stk
+}
+
+// updateControlPlaneAcl adds and removes ACL addresses, pins the result in
+// infra stack config as controlPlaneAcl, which the infra project prefers over
+// the platform config, and deploys the infra stack. Adding an address enables
+// the ACL and removing the last one disables it, so the control plane is never
+// left unreachable.
+func updateControlPlaneAcl(ctx context.Context, add, remove []string) error {
+	stk := infraStack(ctx)
+	s := initLocalStack(ctx, stk)
+	cur := stackControlPlaneAcl(ctx, s)
+	want := ControlPlaneAcl{
+		Enabled: cur.Enabled,
+		Ipv4:    slices.Clone(cur.Ipv4),
+		Ipv6:    slices.Clone(cur.Ipv6),
+	}
+
+	for _, i := range add {
+		cidr, err := parseCidr(i)
+		if err != nil {
+			return err
+		}
+
+		want.Enabled = true
+
+		switch {
+		case strings.Contains(cidr, ":") && !slices.Contains(want.Ipv6, cidr):
+			want.Ipv6 = append(want.Ipv6, cidr)
+		case !strings.Contains(cidr, ":") && !slices.Contains(want.Ipv4, cidr):
+			want.Ipv4 = append(want.Ipv4, cidr)
+		}
+	}
+
+	for _, i := range remove {
+		cidr, err := parseCidr(i)
+		if err != nil {
+			return err
+		}
+
+		if !slices.Contains(want.Ipv4, cidr) && !slices.Contains(want.Ipv6, cidr) {
+			logger.Warn(cidr + " is not in the control plane acl")
+		}
+
+		match := func(c string) bool { return c == cidr }
+		want.Ipv4 = slices.DeleteFunc(want.Ipv4, match)
+		want.Ipv6 = slices.DeleteFunc(want.Ipv6, match)
+	}
+
+	if want.Enabled && len(want.Ipv4)+len(want.Ipv6) == 0 {
+		logger.Warn("no addresses left in the control plane acl: disabling it")
+
+		want.Enabled = false
+	}
+
+	if want.Enabled == cur.Enabled && slices.Equal(want.Ipv4, cur.Ipv4) && slices.Equal(want.Ipv6, cur.Ipv6) {
+		logger.Info("control plane acl is already up to date")
+
+		return nil
+	}
+
+	addrs := strings.Join(slices.Concat(want.Ipv4, want.Ipv6), ", ")
+	if !want.Enabled {
+		addrs = "anywhere"
+	}
+
+	// kubectl and deploys from here fail once an address of this machine is
+	// left out, which is easy to miss on dual-stack networks
+	if want.Enabled {
+		ips, err := myIps(ctx)
+		if err != nil {
+			logger.Warn("cannot check the acl against this machine: " + err.Error())
+		}
+
+		for _, i := range ips {
+			if !aclContains(want, i) {
+				logger.Warn("the control plane will not be reachable from " + i + ": it is not in the acl")
+			}
+		}
+	}
+
+	prompt := fmt.Sprintf("allow control plane access from %s? (type YES to confirm)", addrs)
+	if ok := InputPrompt("warn", "YES", prompt); !ok {
+		logger.Warn("acl update cancelled")
+
+		return nil
+	}
+
+	data, err := json.Marshal(want)
+	if err != nil {
+		return err
+	}
+
+	if err := s.SetConfig(ctx, "controlPlaneAcl", auto.ConfigValue{Value: string(data)}); err != nil {
+		return errors.New("set controlPlaneAcl in infra stack config: " + err.Error())
+	}
+
+	stk.Up(ctx)
+
+	return nil
+}
+
+// stackControlPlaneAcl returns the ACL pinned in stack config, falling back
+// to the platform config.
+func stackControlPlaneAcl(ctx context.Context, s auto.Stack) ControlPlaneAcl {
+	v, err := s.GetConfig(ctx, "controlPlaneAcl")
+	if err != nil || v.Value == "" {
+		return platform.ControlPlaneAcl
+	}
+
+	var acl ControlPlaneAcl
+	if err := json.Unmarshal([]byte(v.Value), &acl); err != nil {
+		logger.Warn("json unmarshal controlPlaneAcl: " + err.Error())
+
+		return platform.ControlPlaneAcl
+	}
+
+	return acl
+}
+
+// aclContains reports if the ACL allows a CIDR from parseCidr.
+func aclContains(acl ControlPlaneAcl, cidr string) bool {
+	ip, _, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return false
+	}
+
+	for _, i := range slices.Concat(acl.Ipv4, acl.Ipv6) {
+		if _, ipnet, err := net.ParseCIDR(i); err == nil && ipnet.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// myIps returns the public IPv4 and IPv6 addresses of this machine as CIDRs.
+// A family the network has no route for is left out.
+func myIps(ctx context.Context) ([]string, error) {
+	var (
+		ips  []string
+		errs []error
+	)
+
+	for _, i := range myIpURLs {
+		ip, err := myIp(ctx, i)
+		if err != nil {
+			errs = append(errs, err)
+
+			continue
+		}
+
+		ips = append(ips, ip)
+	}
+
+	if len(ips) == 0 {
+		return nil, errors.Join(errs...)
+	}
+
+	return ips, nil
+}
+
+// myIp returns the public IP address of this machine as seen by url.
+func myIp(ctx context.Context, url string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return "", err
+	}
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", errors.New("get current public ip: " + err.Error())
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return "", errors.New("get current public ip: " + res.Status)
+	}
+
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return "", err
+	}
+
+	return parseCidr(strings.TrimSpace(string(body)))
+}
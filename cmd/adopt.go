@@ -0,0 +1,382 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"text/template"
+	"time"
+
+	"github.com/linode/linodego"
+	"github.com/pulumi/pulumi/sdk/v3/go/auto"
+	"github.com/pulumi/pulumi/sdk/v3/go/auto/optimport"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var (
+	adoptDomain string
+	adoptEmail  string
+	adoptLkeId  int
+	adoptNbId   int
+	adoptStack  string
+)
+
+// importFile is the JSON file format read by `pulumi import --file`.
+type importFile struct {
+	Resources []*optimport.ImportResource `json:"resources"`
+}
+
+var adoptCmd = &cobra.Command{
+	Use:   "adopt",
+	Short: "Adopt an existing LKE cluster and domain into a new platform",
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Minute)
+		defer cancel()
+
+		name := cmd.Flag("name").Value.String()
+		client := NewLinodeClient()
+
+		cluster, err := client.GetLKECluster(ctx, adoptLkeId)
+		if err != nil {
+			logger.Error("get lke cluster: " + err.Error())
+
+			return
+		}
+
+		pools, err := client.ListLKENodePools(ctx, adoptLkeId, &linodego.ListOptions{})
+		if err != nil || len(pools) == 0 {
+			logger.Error("list lke node pools: no node pools found")
+
+			return
+		}
+
+		filter := fmt.Sprintf(`{"domain": %q}`, adoptDomain)
+
+		domains, err := client.ListDomains(ctx, linodego.NewListOptions(0, filter))
+		if err != nil || len(domains) == 0 {
+			logger.Error("find linode domain: " + adoptDomain + " not found")
+
+			return
+		}
+
+		// platform definition derived from the live resources
+		if !platformDefined(name) {
+			platform = adoptedPlatform(name, cluster, pools, domains[0])
+
+			if err := appendPlatformConfig(platform); err != nil {
+				logger.Error("write platform config: " + err.Error())
+
+				return
+			}
+
+			logger.Info("platform config appended to: " + viper.ConfigFileUsed())
+		}
+
+		createPlatform(ctx)
+
+		// record the cluster id so cleanup funcs can resolve it before the
+		// first deploy
+		esc := NewEnvObject(viper.GetString("pulumiOrg"), platform.Name, platform.Stack)
+		esc.AddConfig(map[string]any{"lkeId": `${lke.id}`})
+		esc.Items = map[int]EscEnvItem{
+			1: {Name: "lke", Value: map[string]int{"id": cluster.ID}},
+		}
+		if err := platformStore().Update(&esc); err != nil {
+			logger.Error("record lke id in secret store: " + err.Error())
+		}
+
+		stk := &MicroStack{Name: "infra"}
+		stk.Path = filepath.Join(paths.Projects, platform.Name, "cmd", stk.Name)
+		stk.GetFullName(ctx)
+
+		s := initLocalStack(ctx, stk)
+
+		// the cluster resource carries every pool, so the generated pools
+		// must match the live ones before the first deploy
+		data, err := json.Marshal(adoptedNodePools(pools))
+		if err != nil {
+			logger.Error("json marshal node pools: " + err.Error())
+
+			return
+		}
+
+		if err := s.SetConfig(ctx, "nodePools", auto.ConfigValue{Value: string(data)}); err != nil {
+			logger.Error("set nodePools in infra stack config: " + err.Error())
+
+			return
+		}
+
+		nb := clusterNodeBalancer(ctx, client, cluster)
+
+		if err := importPlatform(ctx, s, stk.Path, cluster, pools, domains[0], nb); err != nil {
+			logger.Error("import platform resources: " + err.Error())
+
+			return
+		}
+
+		if nb != nil {
+			adoptNodeBalancer(ctx, s, client, nb)
+		}
+
+		logger.Info("platform adopted: run 'deploy' to reconcile the remaining resources")
+	},
+}
+
+func init() {
+	// required flags
+	adoptCmd.Flags().StringVarP(&platform.Name, "name", "n", "", "APL instance name (required)")
+	adoptCmd.MarkFlagRequired("name") //nolint:errcheck
+	adoptCmd.Flags().IntVarP(&adoptLkeId, "lke-id", "", 0, "Existing LKE cluster ID (required)")
+	adoptCmd.MarkFlagRequired("lke-id") //nolint:errcheck
+	adoptCmd.Flags().StringVarP(&adoptDomain, "domain", "d", "", "Existing Linode DNS domain (required)")
+	adoptCmd.MarkFlagRequired("domain") //nolint:errcheck
+	// optional flags
+	adoptCmd.Flags().StringVarP(&adoptEmail, "email", "e", "", "SOA and cert-manager email (default is domain SOA email)")
+	adoptCmd.Flags().IntVarP(&adoptNbId, "nodebalancer-id", "", 0, "Existing NodeBalancer ID")
+	adoptCmd.Flags().StringVarP(&adoptStack, "stack", "", "dev", "Pulumi stack name")
+
+	_ = viper.BindPFlags(adoptCmd.LocalFlags())
+}
+
+// platformDefined reports if a platform definition with the given name is
+// present in the config file.
+func platformDefined(name string) bool {
+	for _, i := range cfgArray {
+		if i["name"] == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+func adoptedPlatform(name string, c *linodego.LKECluster, pools []linodego.LKENodePool, d linodego.Domain) Platform {
+	pool := pools[0]
+
+	email := adoptEmail
+	if email == "" {
+		email = d.SOAEmail
+	}
+
+	tags := c.Tags
+	if len(tags) == 0 {
+		tags = []string{"apl", adoptStack}
+	}
+
+	p := Platform{
+		AplVersion:  defaultAplVersion,
+		Domain:      d.Domain,
+		Email:       email,
+		KubeVersion: c.K8sVersion,
+		LkeTier:     c.Tier,
+		Name:        name,
+		NbTag:       "apl-static-lb",
+		NodeCount:   pool.Count,
+		NodeMax:     pool.Autoscaler.Max,
+		NodePools:   adoptedNodePools(pools),
+		NodeType:    pool.Type,
+		ObjPrefix:   "apl",
+		Region:      c.Region,
+		Repo:        "github.com/akamai-developers/aplcli",
+		Stack:       adoptStack,
+		Tags:        tags,
+		Values:      valuesFile,
+	}
+
+	if pool.Autoscaler.Enabled {
+		p.NodeCount = pool.Autoscaler.Min
+	}
+
+	if p.NodeMax < p.NodeCount {
+		p.NodeMax = p.NodeCount
+	}
+
+	return p
+}
+
+// adoptedNodePools converts the live node pools to the nodePools config. A
+// pool is named after its nodepool label, as set by the infra project, or
+// its LKE label or id.
+func adoptedNodePools(pools []linodego.LKENodePool) []NodePoolConfig {
+	res := make([]NodePoolConfig, 0, len(pools))
+
+	for _, i := range pools {
+		labels := maps.Clone(map[string]string(i.Labels))
+		delete(labels, "nodepool")
+
+		np := NodePoolConfig{
+			Count:  i.Count,
+			Labels: labels,
+			Name:   i.Labels["nodepool"],
+			Tags:   i.Tags,
+			Type:   i.Type,
+		}
+
+		if np.Name == "" && i.Label != nil {
+			np.Name = *i.Label
+		}
+
+		if np.Name == "" {
+			np.Name = fmt.Sprintf("pool-%d", i.ID)
+		}
+
+		if i.Autoscaler.Enabled {
+			np.Min = i.Autoscaler.Min
+			np.Max = max(i.Autoscaler.Max, i.Count)
+		}
+
+		for _, t := range i.Taints {
+			np.Taints = append(np.Taints, NodeTaint{Effect: string(t.Effect), Key: t.Key, Value: t.Value})
+		}
+
+		res = append(res, np)
+	}
+
+	return res
+}
+
+// appendPlatformConfig appends a platform definition to the platform array in
+// config.yaml, leaving the rest of the file untouched.
+func appendPlatformConfig(p Platform) error {
+	buf := &bytes.Buffer{}
+	data := tplParser(p)
+
+	t := template.Must(template.New("platform.tpl").ParseFS(templates, "templates/init/platform.tpl"))
+	if err := t.Execute(buf, &data); err != nil {
+		return err
+	}
+
+	file := viper.ConfigFileUsed()
+	if file == "" {
+		file = filepath.Join(paths.Config, "config.yaml")
+	}
+
+	f, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+
+	if !bytes.HasSuffix(f, []byte("\n")) {
+		f = append(f, '\n')
+	}
+
+	return os.WriteFile(file, append(f, buf.Bytes()...), 0600)
+}
+
+// importPlatform writes a pulumi import file for the cluster, its node pools,
+// the domain and the NodeBalancer to the infra project, then imports them into
+// the stack state using the logical names of the generated code. Node pools
+// are part of the cluster resource, so they are imported along with it and
+// listed in the output.
+func importPlatform(ctx context.Context, s auto.Stack, path string, c *linodego.LKECluster,
+	pools []linodego.LKENodePool, d linodego.Domain, nb *linodego.NodeBalancer,
+) error {
+	imports := importFile{
+		Resources: []*optimport.ImportResource{
+			{
+				Type: "linode:index/lkeCluster:LkeCluster",
+				Name: platform.Name,
+				ID:   strconv.Itoa(c.ID),
+			},
+			{
+				Type: "linode:index/domain:Domain",
+				Name: d.Domain,
+				ID:   strconv.Itoa(d.ID),
+			},
+		},
+	}
+
+	for _, i := range adoptedNodePools(pools) {
+		logger.Info("node pool imported with the cluster: " + i.String())
+	}
+
+	// the infra project declares the adopted NodeBalancer under this name
+	if nb != nil {
+		imports.Resources = append(imports.Resources, &optimport.ImportResource{
+			Type: "linode:index/nodeBalancer:NodeBalancer",
+			Name: platform.Name + "-nodebalancer",
+			ID:   strconv.Itoa(nb.ID),
+		})
+	}
+
+	data, err := json.MarshalIndent(imports, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	file := filepath.Join(path, "import.json")
+	if err := os.WriteFile(file, data, 0600); err != nil {
+		return err
+	}
+
+	logger.Info("import file written to: " + file)
+
+	stdout := optimport.ProgressStreams(os.Stdout)
+
+	_, err = s.ImportResources(ctx, stdout, optimport.ImportFile(file),
+		optimport.GenerateCode(false), optimport.Protect(false))
+
+	return err
+}
+
+// clusterNodeBalancer finds the NodeBalancer of the cluster, or the one given
+// with --nodebalancer-id.
+func clusterNodeBalancer(ctx context.Context, client linodego.Client, c *linodego.LKECluster) *linodego.NodeBalancer {
+	nodebalancers, err := client.ListNodeBalancers(ctx, &linodego.ListOptions{})
+	if err != nil {
+		logger.Error("list nodebalancers: " + err.Error())
+
+		return nil
+	}
+
+	for idx, i := range nodebalancers {
+		switch {
+		case adoptNbId != 0 && i.ID == adoptNbId:
+			return &nodebalancers[idx]
+		case adoptNbId == 0 && i.Region == c.Region && i.Label != nil:
+			// match the exact lke<id>, so lke12 does not match lke123
+			if id, ok := lkeLabelID(*i.Label); ok && id == c.ID {
+				return &nodebalancers[idx]
+			}
+		}
+	}
+
+	logger.Warn("no nodebalancer found: one will be created on deploy")
+
+	return nil
+}
+
+// adoptNodeBalancer tags the cluster's existing NodeBalancer so the generated
+// StaticLoadbalancer finds it instead of creating a new service. The
+// NodeBalancer stays owned by the CCM: the infra project keeps it in state
+// without managing its settings, and retains it when it is removed.
+func adoptNodeBalancer(ctx context.Context, s auto.Stack, client linodego.Client, nb *linodego.NodeBalancer) {
+	tags := nb.Tags
+	for _, i := range []string{platform.NbTag, platformTag(), "kubernetes"} {
+		if !slices.Contains(tags, i) {
+			tags = append(tags, i)
+		}
+	}
+
+	if _, err := client.UpdateNodeBalancer(ctx, nb.ID, linodego.NodeBalancerUpdateOptions{Tags: &tags}); err != nil {
+		logger.Error("tag nodebalancer: " + err.Error())
+
+		return
+	}
+
+	for _, key := range []string{"nodebalancer-id", "adopted-nodebalancer-id"} {
+		if err := s.SetConfig(ctx, key, auto.ConfigValue{Value: strconv.Itoa(nb.ID)}); err != nil {
+			logger.Error("failed to set " + key + " in pulumi stack config")
+		}
+	}
+
+	msg := fmt.Sprintf("adopted nodebalancer %d", nb.ID)
+	logger.Info(msg)
+}
@@ -0,0 +1,231 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/auto"
+)
+
+// archiveManifest records what was copied from each platform bucket. It is
+// written next to the archived buckets.
+type archiveManifest struct {
+	Platform string          `json:"platform"`
+	Created  time.Time       `json:"created"`
+	Source   string          `json:"source"`
+	Dest     string          `json:"dest"`
+	Buckets  []archiveBucket `json:"buckets"`
+}
+
+type archiveBucket struct {
+	Bucket  string        `json:"bucket"`
+	Dest    string        `json:"dest"`
+	Objects int           `json:"objects"`
+	Bytes   int64         `json:"bytes"`
+	Errors  int64         `json:"errors"`
+	Files   []archiveFile `json:"files"`
+}
+
+type archiveFile struct {
+	Path    string `json:"path"`
+	Size    int64  `json:"size"`
+	ModTime string `json:"modTime"`
+}
+
+// archiveObj copies every platform bucket to the --archive-obj remote before
+// any stack is destroyed. Destroy stops if the destination is not writable or
+// any bucket fails to copy.
+func archiveObj(ctx context.Context, s auto.Stack) {
+	if err := checkArchiveDst(archiveObjDst); err != nil {
+		logger.Error("archive destination " + archiveObjDst + ": " + err.Error())
+		logger.Warn("destroy stopped: nothing was destroyed")
+		os.Exit(1)
+	}
+
+	objRemote := stackObjRemote(ctx, s)
+	objRemote.Init(ctx)
+
+	manifest, err := objRemote.Archive(ctx, archiveObjDst)
+	if err != nil {
+		logger.Error("archive obj buckets: " + err.Error())
+		logger.Warn("destroy stopped: nothing was destroyed")
+		os.Exit(1)
+	}
+
+	logger.Info("header:archived buckets")
+
+	for _, i := range manifest.Buckets {
+		line := fmt.Sprintf("%-40s %8d objects %12s  -> %s", i.Bucket, i.Objects, formatBytes(i.Bytes), i.Dest)
+		logger.Info("line:" + line)
+	}
+
+	fmt.Println() //nolint:forbidigo
+}
+
+// Archive copies the buckets to dst/<bucket> with rclone sync/copy jobs,
+// showing progress while they run, then lists what arrived and writes a
+// manifest to dst.
+func (r *s3Remote) Archive(ctx context.Context, dst string) (archiveManifest, error) {
+	dst = strings.TrimSuffix(dst, "/")
+	manifest := archiveManifest{
+		Platform: platform.Name,
+		Created:  time.Now().UTC(),
+		Source:   r.Endpoint,
+		Dest:     dst,
+	}
+
+	buckets := make([]string, 0, len(r.Buckets))
+	for _, v := range r.Buckets {
+		buckets = append(buckets, v)
+	}
+
+	slices.Sort(buckets)
+
+	for idx, bucket := range buckets {
+		b := archiveBucket{Bucket: bucket, Dest: dst + "/" + bucket}
+		group := "archive/" + bucket
+
+		req := syncRequest{
+			SrcFs: r.Fs(bucket),
+			DstFs: b.Dest,
+			Group: group,
+			Async: true,
+		}
+
+		stats, err := rcloneJob(ctx, "sync/copy", req, group, func(st rcloneStats) {
+			msg := fmt.Sprintf("(%d/%d) archiving %s: %s/%s, %d/%d objects", idx+1, len(buckets), bucket,
+				formatBytes(st.Bytes), formatBytes(st.TotalBytes), st.Transfers, st.TotalTransfers)
+			logger.Info(msg)
+		})
+		if err != nil {
+			return manifest, fmt.Errorf("copy bucket %s: %w", bucket, err)
+		}
+
+		b.Errors = stats.Errors
+
+		if b.Files, err = rcloneListFiles(b.Dest); err != nil {
+			return manifest, fmt.Errorf("list archived bucket %s: %w", bucket, err)
+		}
+
+		for _, i := range b.Files {
+			b.Objects++
+			b.Bytes += i.Size
+		}
+
+		manifest.Buckets = append(manifest.Buckets, b)
+	}
+
+	if err := writeArchiveManifest(manifest); err != nil {
+		return manifest, fmt.Errorf("write manifest: %w", err)
+	}
+
+	return manifest, nil
+}
+
+// checkArchiveDst creates the archive destination and writes and removes a
+// probe file, so a wrong remote or missing permission is found before the
+// buckets are copied.
+func checkArchiveDst(dst string) error {
+	dst = strings.TrimSuffix(dst, "/")
+
+	req, err := json.Marshal(map[string]string{"fs": dst, "remote": ""})
+	if err != nil {
+		return err
+	}
+
+	if res, status := rcloneAction("operations/mkdir", string(req)); status != 200 {
+		return rcloneError("operations/mkdir", status, res)
+	}
+
+	tmp, err := os.MkdirTemp("", "aplcli-archive-")
+	if err != nil {
+		return err
+	}
+
+	defer os.RemoveAll(tmp)
+
+	name := ".aplcli-archive-check"
+	if err := os.WriteFile(filepath.Join(tmp, name), []byte(platform.Name+"\n"), 0600); err != nil {
+		return err
+	}
+
+	if err := rcloneCopyFile(tmp, name, dst, name); err != nil {
+		return err
+	}
+
+	req, err = json.Marshal(map[string]string{"fs": dst, "remote": name})
+	if err != nil {
+		return err
+	}
+
+	if res, status := rcloneAction("operations/deletefile", string(req)); status != 200 {
+		return rcloneError("operations/deletefile", status, res)
+	}
+
+	return nil
+}
+
+// rcloneListFiles lists every object under an rclone fs.
+func rcloneListFiles(fsName string) ([]archiveFile, error) {
+	var list struct {
+		List []archiveFile `json:"list"`
+	}
+
+	req := map[string]any{
+		"fs":     fsName,
+		"remote": "",
+		"opt":    map[string]bool{"recurse": true, "filesOnly": true, "noMimeType": true},
+	}
+
+	reqJSON, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+
+	res, status := rcloneAction("operations/list", string(reqJSON))
+	if status != 200 {
+		return nil, rcloneError("operations/list", status, res)
+	}
+
+	// rclone lists entries with capitalized keys, which json matches
+	// case-insensitively against the archiveFile tags
+	if err := json.Unmarshal([]byte(res), &list); err != nil {
+		return nil, err
+	}
+
+	return list.List, nil
+}
+
+// writeArchiveManifest copies the manifest to the root of the archive.
+func writeArchiveManifest(m archiveManifest) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.MkdirTemp("", "aplcli-archive-")
+	if err != nil {
+		return err
+	}
+
+	defer os.RemoveAll(tmp)
+
+	name := fmt.Sprintf("%s-manifest-%s.json", m.Platform, m.Created.Format("20060102T150405Z"))
+	if err := os.WriteFile(filepath.Join(tmp, name), data, 0600); err != nil {
+		return err
+	}
+
+	if err := rcloneCopyFile(tmp, name, m.Dest, name); err != nil {
+		return err
+	}
+
+	logger.Info("archive manifest written to " + m.Dest + "/" + name)
+
+	return nil
+}
@@ -2,10 +2,13 @@ package cmd
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"net/http"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"strconv"
 	"strings"
@@ -35,6 +38,7 @@ type MicroStack struct {
 	Path     string
 	PreRun   []string
 	PostRun  []string
+	Replace  []string
 }
 
 type StackMap map[int]*MicroStack
@@ -66,6 +70,7 @@ var PrePostFn = map[string]PrePostCmd{
 	"deleteObj":         deleteObj,
 	"addNodeBalancerId": addNodeBalancerId,
 	"rmNodeBalancerId":  rmNodeBalancerId,
+	"recycleNodePools":  recycleNodePools,
 }
 
 func (stk *MicroStack) GetFullName(ctx context.Context) {
@@ -112,6 +117,21 @@ func (stk *MicroStack) PrePostRun(ctx context.Context, s auto.Stack, action stri
 	}
 }
 
+// Tag sets pulumi stack tags used for ownership and cost attribution, which can
+// be queried with the list command.
+func (stk *MicroStack) Tag(ctx context.Context, s auto.Stack) {
+	for k, v := range stackTags() {
+		if v == "" {
+			continue
+		}
+
+		if err := s.SetTag(ctx, k, v); err != nil {
+			msg := fmt.Sprintf("failed to set %s tag on %s stack: %s", k, stk.Name, err.Error())
+			logger.Error(msg)
+		}
+	}
+}
+
 func (stk *MicroStack) Up(ctx context.Context) {
 	stdout := optup.ProgressStreams(os.Stdout)
 	s := initLocalStack(ctx, stk)
@@ -120,11 +140,17 @@ func (stk *MicroStack) Up(ctx context.Context) {
 		logger.Error("failed to refresh stack on pulumi deploy: " + err.Error())
 	}
 
+	stk.Tag(ctx, s)
 	stk.PrePostRun(ctx, s, "pre")
 	msg := fmt.Sprintf("deploying %s stack", stk.Name)
 	logger.Info(msg)
 
-	_, err := s.Up(ctx, stdout, colorUp{}, parallelismUp{})
+	opts := []optup.Option{stdout, colorUp{}, parallelismUp{}}
+	if len(stk.Replace) > 0 {
+		opts = append(opts, optup.Replace(stk.Replace))
+	}
+
+	_, err := s.Up(ctx, opts...)
 	if err != nil {
 		logger.Error("failed to deploy stack: " + err.Error())
 	}
@@ -170,6 +196,23 @@ func (stk *MicroStack) Remove(ctx context.Context) {
 	}
 }
 
+func stackTags() map[string]string {
+	cfg, err := yaml.Marshal(platform)
+	if err != nil {
+		logger.Error("yaml marshal platform config for hash: " + err.Error())
+	}
+
+	sum := sha256.Sum256(cfg)
+
+	return map[string]string{
+		"platform":      platform.Name,
+		"region":        platform.Region,
+		"owner":         platform.Email,
+		"configHash":    hex.EncodeToString(sum[:])[:12],
+		"aplcliVersion": version,
+	}
+}
+
 func stackExists(ctx context.Context, fqsn string) bool {
 	auth := "token %" + os.Getenv("PULUMI_ACCESS_TOKEN")
 	apiURL := `https://api.pulumi.com/api/stacks/` + fqsn
@@ -204,6 +247,12 @@ func initLocalStack(ctx context.Context, stk *MicroStack) auto.Stack {
 		logger.Error(msg)
 	}
 
+	// stores other than esc cannot be imported by the stack, so their
+	// config is copied in instead
+	if ds, ok := platformStore().(documentStore); ok {
+		syncStoreConfig(ctx, s, ds)
+	}
+
 	msg := fmt.Sprintf("using %s stack", stk.Name)
 	logger.Info(msg)
 
@@ -220,15 +269,28 @@ func addNodeBalancerId(ctx context.Context, s auto.Stack) {
 }
 
 func rmNodeBalancerId(ctx context.Context, s auto.Stack) {
-	_, lkeId := getResourceVar(ctx, "lkeId", s)
-
-	deleteNodeBalancers(ctx, lkeId)
+	cleanupNodeBalancers(ctx, s)
 
 	if err := s.RemoveConfig(ctx, "nodebalancer-id"); err != nil {
 		logger.Error("failed to remove nodebalancer-id from pulumi stack config")
 	}
 }
 
+// cleanupNodeBalancers deletes the NodeBalancers of the stack's LKE cluster
+// and the one set as nodebalancer-id in stack config.
+func cleanupNodeBalancers(ctx context.Context, s auto.Stack) {
+	_, lkeId := getResourceVar(ctx, "lkeId", s)
+
+	nbid := 0
+	if v, err := s.GetConfig(ctx, "nodebalancer-id"); err == nil {
+		nbid, _ = strconv.Atoi(v.Value)
+	}
+
+	report := &cleanupReport{}
+	deleteNodeBalancers(ctx, lkeId, nbid, report)
+	report.print("nodebalancer cleanup")
+}
+
 func cleanupLke(ctx context.Context, s auto.Stack) {
 	_, lkeId := getResourceVar(ctx, "lkeId", s)
 
@@ -236,10 +298,31 @@ func cleanupLke(ctx context.Context, s auto.Stack) {
 }
 
 func deleteObj(ctx context.Context, s auto.Stack) {
+	objRemote := stackObjRemote(ctx, s)
+	objRemote.PurgeEnabled = false
+	objRemote.StateFile = filepath.Join(paths.Config, "purge", platform.Name+".json")
+
+	objRemote.Init(ctx)
+
+	// jobs are stopped on interrupt, finished buckets are skipped next time
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt)
+	err := objRemote.Purge(ctx)
+
+	stop()
+
+	if err != nil {
+		logger.Error("purge obj buckets: " + err.Error())
+		logger.Warn("destroy stopped: run it again to resume the purge")
+		os.Exit(1)
+	}
+}
+
+// stackObjRemote returns an s3Remote for the platform buckets, with the bucket
+// names and key read from the stack config.
+func stackObjRemote(ctx context.Context, s auto.Stack) s3Remote {
 	objRemote := s3Remote{
-		Endpoint:     platform.Region + "-1.linodeobjects.com",
-		Remote:       platform.Name,
-		PurgeEnabled: false,
+		Endpoint: platform.Region + "-1.linodeobjects.com",
+		Remote:   platform.Name,
 	}
 
 	buckets, err := s.GetConfig(ctx, "apl:objBuckets")
@@ -261,8 +344,7 @@ func deleteObj(ctx context.Context, s auto.Stack) {
 		logger.Error("json unmarshal obj key data: " + err.Error())
 	}
 
-	objRemote.Init(ctx)
-	objRemote.Purge(ctx)
+	return objRemote
 }
 
 func getResourceVar(ctx context.Context, v string, s auto.Stack) (string, int) {
@@ -271,11 +353,12 @@ func getResourceVar(ctx context.Context, v string, s auto.Stack) (string, int) {
 		idVal, err := s.GetConfig(ctx, "lkeId")
 		if err != nil {
 			if strings.Contains(err.Error(), "unable to read config: exit status 255") {
-				// If not in stack's state, try to get it from esc environment,
+				// If not in stack's state, try to get it from the secret store,
 				// in case this is just an issue of ordering.
-				orgName := viper.GetString("pulumiOrg")
-				esc := NewEnvObject(orgName, platform.Name, platform.Stack)
-				_, id := esc.GetConfig("lkeId") //nolint:contextcheck
+				id, err := Get[int](platformStore(), "lkeId") //nolint:contextcheck
+				if err != nil {
+					logger.Error("get lkeId from secret store: " + err.Error())
+				}
 
 				return "", id
 			}
@@ -0,0 +1,390 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	age "filippo.io/age"
+	esc "github.com/pulumi/esc-sdk/sdk/go"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	yaml "gopkg.in/yaml.v3"
+)
+
+// envBackup is the plaintext of a backup file. The yaml is the decrypted
+// environment definition, since ciphertext can only be read by the
+// environment that wrote it.
+type envBackup struct {
+	Org         string    `json:"org"`
+	Project     string    `json:"project"`
+	Environment string    `json:"environment"`
+	Created     time.Time `json:"created"`
+	Yaml        string    `json:"yaml"`
+}
+
+var (
+	backupRecipients []string
+	backupTo         string
+	restoreForce     bool
+	restoreIdentity  string
+)
+
+var envBackupCmd = &cobra.Command{
+	Use:    "backup",
+	Short:  "Write an age-encrypted snapshot of the ESC environment",
+	Args:   cobra.NoArgs,
+	PreRun: escStoreOnly,
+	Run: func(cmd *cobra.Command, args []string) {
+		file, err := backupEnv(backupTo)
+		if err != nil {
+			logger.Error("backup esc environment: " + err.Error())
+
+			return
+		}
+
+		logger.Info("esc environment backed up to " + file)
+	},
+}
+
+var envRestoreCmd = &cobra.Command{
+	Use:    "restore <file|remote:path>",
+	Short:  "Restore the ESC environment from a backup",
+	Args:   cobra.ExactArgs(1),
+	PreRun: escStoreOnly,
+	Run: func(cmd *cobra.Command, args []string) {
+		org := viper.GetString("pulumiOrg")
+
+		b, err := readEnvBackup(args[0], restoreIdentity)
+		if err != nil {
+			logger.Error("read esc environment backup: " + err.Error())
+
+			return
+		}
+
+		src := fmt.Sprintf("%s/%s/%s", b.Org, b.Project, b.Environment)
+		logger.Info("header:backup of " + src + " from " + b.Created.Format(time.RFC3339))
+
+		// a backup of another platform would replace its credentials and
+		// config, so it is only restored on request
+		dst := fmt.Sprintf("%s/%s/%s", org, platform.Name, platform.Stack)
+		if src != dst {
+			if !restoreForce {
+				logger.Error("restore: backup of " + src + " does not match " + dst + ": use --force to restore it anyway")
+
+				return
+			}
+
+			logger.Warn("restoring backup of " + src + " to " + dst)
+		}
+
+		authCtx, escClient, err := esc.DefaultLogin()
+		if err != nil {
+			logger.Error("pulumi esc restore login: " + err.Error())
+
+			return
+		}
+
+		if EscExists(org, platform.Name, platform.Stack) {
+			next, err := backupDefinitionMap(b.Yaml)
+			if err != nil {
+				logger.Error("read esc environment backup: " + err.Error())
+
+				return
+			}
+
+			cur, _, err := escClient.DecryptEnvironment(authCtx, org, platform.Name, platform.Stack)
+			if err != nil {
+				logger.Error("decrypt esc environment: " + err.Error())
+
+				return
+			}
+
+			logger.Info("header:" + fmt.Sprintf("%s/%s/%s changes", org, platform.Name, platform.Stack))
+			printEnvChanges(diffEnv(envDefinitionMap(cur), next))
+
+			prompt := fmt.Sprintf("overwrite esc environment %s/%s/%s? (type YES to confirm)",
+				org, platform.Name, platform.Stack)
+			if ok := InputPrompt("warn", "YES", prompt); !ok {
+				logger.Warn("restore cancelled")
+
+				return
+			}
+		} else if err := escClient.CreateEnvironment(authCtx, org, platform.Name, platform.Stack); err != nil {
+			logger.Error("create esc environment: " + err.Error())
+
+			return
+		}
+
+		// plaintext fn::secret values are encrypted again by esc on write
+		if _, err := escClient.UpdateEnvironmentYaml(authCtx, org, platform.Name, platform.Stack, b.Yaml); err != nil {
+			logger.Error("write esc environment: " + err.Error())
+
+			return
+		}
+
+		logger.Info("esc environment restored from " + args[0])
+	},
+}
+
+func init() {
+	// optional flags
+	envBackupCmd.Flags().StringVarP(&backupTo, "to", "", "",
+		"Local directory or rclone remote:path to write to (default is ~/"+confDir+"/backups)")
+	envBackupCmd.Flags().StringArrayVarP(&backupRecipients, "recipient", "", []string{},
+		"Additional age public key to encrypt to")
+	envRestoreCmd.Flags().BoolVarP(&restoreForce, "force", "", false,
+		"Restore a backup of another org, platform or stack")
+	envRestoreCmd.Flags().StringVarP(&restoreIdentity, "identity", "", "",
+		"age identity file (default is ~/"+confDir+"/backups/backup.key)")
+}
+
+// backupDefinitionMap reads the environment definition of a backup in the
+// layout of envDefinitionMap, so it can be diffed against the live one.
+func backupDefinitionMap(data string) (map[string]any, error) {
+	var def struct {
+		Imports []string       `yaml:"imports"`
+		Values  map[string]any `yaml:"values"`
+	}
+
+	if err := yaml.Unmarshal([]byte(data), &def); err != nil {
+		return nil, err
+	}
+
+	m := make(map[string]any)
+	if len(def.Imports) > 0 {
+		m["imports"] = def.Imports
+	}
+
+	if def.Values != nil {
+		m["values"] = def.Values
+	}
+
+	return m, nil
+}
+
+// backupEnv writes the decrypted definition of the platform environment,
+// encrypted to the local backup key and any --recipient, to a directory or an
+// rclone remote. It returns the location of the backup.
+func backupEnv(to string) (string, error) {
+	org := viper.GetString("pulumiOrg")
+	dir := filepath.Join(paths.Config, "backups")
+
+	authCtx, escClient, err := esc.DefaultLogin()
+	if err != nil {
+		return "", err
+	}
+
+	// DecryptEnvironment can return a nil error along with no definition
+	def, yaml, err := escClient.DecryptEnvironment(authCtx, org, platform.Name, platform.Stack)
+	if err != nil || def == nil || yaml == "" {
+		return "", fmt.Errorf("decrypt esc environment %s/%s: %v", platform.Name, platform.Stack, err)
+	}
+
+	id, err := backupIdentity(filepath.Join(dir, "backup.key"), true)
+	if err != nil {
+		return "", err
+	}
+
+	recipients := []age.Recipient{id.Recipient()}
+
+	for _, i := range backupRecipients {
+		r, err := age.ParseX25519Recipient(i)
+		if err != nil {
+			return "", errors.New("parse recipient " + i + ": " + err.Error())
+		}
+
+		recipients = append(recipients, r)
+	}
+
+	b := envBackup{
+		Org:         org,
+		Project:     platform.Name,
+		Environment: platform.Stack,
+		Created:     time.Now().UTC(),
+		Yaml:        yaml,
+	}
+
+	data, err := json.Marshal(b)
+	if err != nil {
+		return "", err
+	}
+
+	buf := &bytes.Buffer{}
+
+	w, err := age.Encrypt(buf, recipients...)
+	if err != nil {
+		return "", err
+	}
+
+	if _, err := w.Write(data); err != nil {
+		return "", err
+	}
+
+	if err := w.Close(); err != nil {
+		return "", err
+	}
+
+	name := fmt.Sprintf("%s-%s-%s.json.age", platform.Name, platform.Stack, b.Created.Format("20060102T150405Z"))
+
+	if to == "" {
+		to = dir
+	}
+
+	if isRcloneRemote(to) {
+		return to + "/" + name, uploadBackup(buf.Bytes(), name, to)
+	}
+
+	if err := os.MkdirAll(to, 0700); err != nil {
+		return "", err
+	}
+
+	file := filepath.Join(to, name)
+
+	return file, os.WriteFile(file, buf.Bytes(), 0600)
+}
+
+// backupIdentity loads the age identity used for backups, creating it when
+// create is set and it does not exist yet.
+func backupIdentity(file string, create bool) (*age.X25519Identity, error) {
+	key, err := os.ReadFile(file)
+	if err == nil {
+		return age.ParseX25519Identity(strings.TrimSpace(string(key)))
+	}
+
+	if !create || !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(file), 0700); err != nil {
+		return nil, err
+	}
+
+	id, err := GenAgeKeys()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.WriteFile(file, []byte(id.String()+"\n"), 0600); err != nil {
+		return nil, err
+	}
+
+	logger.Warn("age key for backups written to " + file + ": keep a copy, backups cannot be restored without it")
+
+	return id, nil
+}
+
+// readEnvBackup reads and decrypts a backup from a file or an rclone remote.
+func readEnvBackup(src, identity string) (envBackup, error) {
+	var b envBackup
+
+	if identity == "" {
+		identity = filepath.Join(paths.Config, "backups", "backup.key")
+	}
+
+	id, err := backupIdentity(identity, false)
+	if err != nil {
+		return b, errors.New("load age identity: " + err.Error())
+	}
+
+	var data []byte
+
+	if isRcloneRemote(src) {
+		data, err = downloadBackup(src)
+	} else {
+		data, err = os.ReadFile(src)
+	}
+
+	if err != nil {
+		return b, err
+	}
+
+	r, err := age.Decrypt(bytes.NewReader(data), id)
+	if err != nil {
+		return b, err
+	}
+
+	plain, err := io.ReadAll(r)
+	if err != nil {
+		return b, err
+	}
+
+	if err := json.Unmarshal(plain, &b); err != nil {
+		return b, errors.New("json unmarshal backup: " + err.Error())
+	}
+
+	return b, nil
+}
+
+// isRcloneRemote reports if a location is an rclone remote:path rather than
+// a local path.
+func isRcloneRemote(s string) bool {
+	remote, _, ok := strings.Cut(s, ":")
+
+	return ok && remote != "" && !strings.ContainsAny(remote, `/\`) && !filepath.IsAbs(s)
+}
+
+// uploadBackup copies a backup to an rclone remote through a temporary
+// directory, so nothing is left on disk.
+func uploadBackup(data []byte, name, to string) error {
+	tmp, err := os.MkdirTemp("", "aplcli-backup-")
+	if err != nil {
+		return err
+	}
+
+	defer os.RemoveAll(tmp)
+
+	if err := os.WriteFile(filepath.Join(tmp, name), data, 0600); err != nil {
+		return err
+	}
+
+	return rcloneCopyFile(tmp, name, to, name)
+}
+
+func downloadBackup(src string) ([]byte, error) {
+	tmp, err := os.MkdirTemp("", "aplcli-restore-")
+	if err != nil {
+		return nil, err
+	}
+
+	defer os.RemoveAll(tmp)
+
+	dir, name := src, ""
+	if i := strings.LastIndex(src, "/"); i > strings.Index(src, ":") {
+		dir, name = src[:i], src[i+1:]
+	} else {
+		dir, name, _ = strings.Cut(src, ":")
+		dir += ":"
+	}
+
+	if err := rcloneCopyFile(dir, name, tmp, name); err != nil {
+		return nil, err
+	}
+
+	return os.ReadFile(filepath.Join(tmp, name))
+}
+
+func rcloneCopyFile(srcFs, srcRemote, dstFs, dstRemote string) error {
+	req, err := json.Marshal(map[string]string{
+		"srcFs":     srcFs,
+		"srcRemote": srcRemote,
+		"dstFs":     dstFs,
+		"dstRemote": dstRemote,
+	})
+	if err != nil {
+		return err
+	}
+
+	res, status := rcloneAction("operations/copyfile", string(req))
+	if status != 200 {
+		return rcloneError("operations/copyfile", status, res)
+	}
+
+	return nil
+}
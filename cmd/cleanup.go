@@ -6,11 +6,14 @@ import (
 	"log/slog"
 	"net/http"
 	"os"
+	"regexp"
 	"slices"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
-	"github.com/go-resty/resty/v2"
 	"github.com/linode/linodego"
 	"golang.org/x/oauth2"
 )
@@ -34,89 +37,395 @@ func (l *LinodeLogger) Debugf(format string, v ...any) {
 	l.log.Debug(fmt.Sprintf(format, v...))
 }
 
+const (
+	cleanupTimeout      = 20 * time.Minute
+	cleanupPollInterval = 5 * time.Second
+	cleanupWaitSeconds  = 300
+	cleanupWorkers      = 4
+)
+
+// cleanupDryRun lists what cleanup would delete without changing anything.
+var cleanupDryRun bool
+
+// lkeLabel matches the lke<id> LKE puts in the labels of nodes and the
+// resources it creates for a cluster.
+var lkeLabel = regexp.MustCompile(`\blke(\d+)\b`)
+
+// cleanupResult is a line of the cleanup report.
+type cleanupResult struct {
+	Kind   string
+	ID     int
+	Label  string
+	Action string
+	Reason string
+}
+
+type cleanupReport struct {
+	results []cleanupResult
+}
+
+func (r *cleanupReport) add(res cleanupResult) {
+	r.results = append(r.results, res)
+}
+
+// print logs what was deleted, skipped or failed and why.
+func (r *cleanupReport) print(title string) {
+	if len(r.results) == 0 {
+		logger.Info(title + ": nothing to clean up")
+
+		return
+	}
+
+	counts := make(map[string]int)
+
+	logger.Info("header:" + title)
+
+	for _, i := range r.results {
+		line := fmt.Sprintf("%-12s %-10d %-40s %-12s %s", i.Kind, i.ID, i.Label, i.Action, i.Reason)
+		logger.Info("line:" + line)
+
+		counts[i.Action]++
+	}
+
+	fmt.Println() //nolint:forbidigo
+
+	msg := fmt.Sprintf("%s: %d deleted, %d skipped, %d failed",
+		title, counts["deleted"], counts["skipped"], counts["failed"])
+	if cleanupDryRun {
+		msg = fmt.Sprintf("%s (dry run): %d would be deleted, %d skipped",
+			title, counts["would delete"], counts["skipped"])
+	}
+
+	if counts["failed"] > 0 {
+		logger.Warn(msg)
+
+		return
+	}
+
+	logger.Info(msg)
+}
+
+// platformTag marks the resources of the running platform, set by the infra
+// stack, the CCM annotation of its NodeBalancer, adopt, or cleanup on the
+// volumes of the cluster it deletes. Cleanup only deletes resources that carry
+// it, or the <name>-volume tag of earlier releases.
+func platformTag() string {
+	return "aplcli-" + platform.Name
+}
+
+func platformTagged(tags []string) bool {
+	return slices.Contains(tags, platformTag()) || slices.Contains(tags, platform.Name+"-volume")
+}
+
+// otherPlatformTag returns the tag of another platform found on a resource.
+func otherPlatformTag(tags []string) string {
+	for _, t := range tags {
+		if strings.HasPrefix(t, "aplcli-") && t != platformTag() {
+			return t
+		}
+	}
+
+	return ""
+}
+
+// lkeLabelID returns the LKE cluster id in a label such as lke12345-67890-abc.
+func lkeLabelID(label string) (int, bool) {
+	m := lkeLabel.FindStringSubmatch(label)
+	if m == nil {
+		return 0, false
+	}
+
+	id, err := strconv.Atoi(m[1])
+
+	return id, err == nil
+}
+
 // purgeLkeClusterResources deletes an entire LKE cluster and any leftover cloud
-// resources such as Block Storage Volumes or NodeBalancers.
+// resources such as Block Storage Volumes, NodeBalancers or the node firewall.
 func purgeLkeClusterResources(ctx context.Context, lkeid int) {
-	// setup client
+	ctx, cancel := context.WithTimeout(ctx, cleanupTimeout)
+	defer cancel()
+
 	client := NewLinodeClient()
-	volumeAttachedRetryCondition := func(r *resty.Response, err error) bool {
-		return r.StatusCode() == 400
-	}
-	client.AddRetryCondition(volumeAttachedRetryCondition)
-	client.SetRetryCount(5).SetRetryMaxWaitTime(10 * time.Second)
+	report := &cleanupReport{}
 
-	// create a list of volume ids
+	// match volumes to the cluster by the instance ids of its nodes, or the
+	// exact lke<id> in their labels
 	// note: this has to be done before deleting the cluster
-	label := fmt.Sprintf("lke%d", lkeid)
+	nodes, err := clusterNodes(ctx, &client, lkeid)
+	if err != nil && !linodego.IsNotFound(err) {
+		logger.Error("list lke node pools: " + err.Error())
+	}
 
 	volumes, err := client.ListVolumes(ctx, &linodego.ListOptions{})
 	if err != nil {
 		logger.Error("list volumes: " + err.Error())
 	}
 
-	volIds := make([]int, 0)
+	purge := make([]linodego.Volume, 0)
 
 	for _, i := range volumes {
-		if strings.Contains(i.LinodeLabel, label) {
-			volIds = append(volIds, i.ID)
+		id, ok := lkeLabelID(i.LinodeLabel)
+		if (i.LinodeID != nil && nodes[*i.LinodeID]) || (ok && id == lkeid) {
+			purge = append(purge, i)
 		}
 	}
 
-	// tag volumes in case we need to rebuild list after lke cluster is deleted
-	tag := platform.Name + "-volume"
+	// volumes matched to the cluster are tagged for the platform before it
+	// is deleted, otherwise only volumes already tagged are deleted
+	if len(purge) > 0 {
+		purge = markVolumes(ctx, &client, purge, report)
+	} else {
+		for _, i := range volumes {
+			if platformTagged(i.Tags) {
+				purge = append(purge, i)
+			}
+		}
+	}
+
+	// delete lke cluster, which deletes its nodes and detaches their volumes
+	deleteLkeCluster(ctx, &client, lkeid, report)
+
+	// delete volumes
+	deleteVolumes(ctx, &client, purge, report)
+
+	// delete nodebalancers
+	deleteNodeBalancers(ctx, lkeid, 0, report)
+
+	// delete the node firewall once the cluster no longer uses it
+	deleteFirewalls(ctx, &client, report)
+
+	report.print("lke cleanup")
+}
+
+// clusterNodes returns the instance ids of the nodes of an LKE cluster.
+func clusterNodes(ctx context.Context, client *linodego.Client, lkeid int) (map[int]bool, error) {
+	nodes := make(map[int]bool)
+
+	pools, err := client.ListLKENodePools(ctx, lkeid, &linodego.ListOptions{})
+	if err != nil {
+		return nodes, err
+	}
+
+	for _, p := range pools {
+		for _, n := range p.Linodes {
+			nodes[n.InstanceID] = true
+		}
+	}
+
+	return nodes, nil
+}
+
+// markVolumes tags the volumes matched to the cluster for the platform, so
+// they can still be found once they are detached. Volumes tagged for another
+// platform are skipped.
+func markVolumes(ctx context.Context, client *linodego.Client, volumes []linodego.Volume, report *cleanupReport) []linodego.Volume {
+	marked := make([]linodego.Volume, 0, len(volumes))
+
+	for _, i := range volumes {
+		res := cleanupResult{Kind: "volume", ID: i.ID, Label: i.Label}
+
+		if other := otherPlatformTag(i.Tags); other != "" {
+			res.Action, res.Reason = "skipped", "tagged "+other
+			report.add(res)
+
+			continue
+		}
+
+		if !cleanupDryRun && !slices.Contains(i.Tags, platformTag()) {
+			tags := append(slices.Clone(i.Tags), platformTag())
 
-	if len(volIds) > 0 {
-		for _, i := range volIds {
-			_, err := client.UpdateVolume(ctx, i, linodego.VolumeUpdateOptions{
-				Tags: &[]string{tag},
-			})
+			vol, err := client.UpdateVolume(ctx, i.ID, linodego.VolumeUpdateOptions{Tags: &tags})
 			if err != nil {
-				logger.Error("tagging linode volumes: " + err.Error())
+				res.Action, res.Reason = "failed", "tag: "+err.Error()
+				report.add(res)
+
+				continue
 			}
+
+			i = *vol
 		}
-	} else {
-		for _, i := range volumes {
-			t := i.Tags
-			if slices.Contains(t, tag) {
-				volIds = append(volIds, i.ID)
-			}
+
+		marked = append(marked, i)
+	}
+
+	return marked
+}
+
+// deleteLkeCluster deletes a cluster and polls until it is gone.
+func deleteLkeCluster(ctx context.Context, client *linodego.Client, lkeid int, report *cleanupReport) {
+	res := cleanupResult{Kind: "lke cluster", ID: lkeid, Label: fmt.Sprintf("lke%d", lkeid)}
+
+	cluster, err := client.GetLKECluster(ctx, lkeid)
+	if err != nil {
+		res.Action, res.Reason = "failed", err.Error()
+		if linodego.IsNotFound(err) {
+			res.Action, res.Reason = "skipped", "not found"
 		}
+
+		report.add(res)
+
+		return
+	}
+
+	res.Label = cluster.Label
+
+	// the infra stack labels the cluster with the platform name, adopted
+	// clusters keep theirs until the first deploy
+	if cluster.Label != platform.Name && !slices.Contains(cluster.Tags, platformTag()) {
+		res.Action, res.Reason = "skipped", "not labeled "+platform.Name+" or tagged "+platformTag()
+		report.add(res)
+
+		return
+	}
+
+	if cleanupDryRun {
+		res.Action = "would delete"
+		report.add(res)
+
+		return
 	}
 
-	// delete lke cluster
 	if err := client.DeleteLKECluster(ctx, lkeid); err != nil {
-		if !strings.Contains(err.Error(), "Not found") {
-			logger.Error("delete lke cluster: " + err.Error())
+		res.Action, res.Reason = "failed", err.Error()
+		if linodego.IsNotFound(err) {
+			res.Action, res.Reason = "skipped", "not found"
 		}
 
-		logger.Info("purged lke cluster")
-		time.Sleep(10 * time.Second)
+		report.add(res)
+
+		return
 	}
 
-	// delete volumes
-	for idx, i := range volIds {
-		idx++
-		msg := fmt.Sprintf("(%d/%d) purging volumes", idx, len(volIds))
-		logger.Info(msg)
+	logger.Info(fmt.Sprintf("waiting for lke cluster %d to be deleted", lkeid))
+
+	if err := waitForLkeClusterDeleted(ctx, client, lkeid); err != nil {
+		res.Action, res.Reason = "failed", err.Error()
+		report.add(res)
+
+		return
+	}
+
+	res.Action = "deleted"
+	report.add(res)
+}
+
+// waitForLkeClusterDeleted polls a cluster until the API no longer finds it,
+// or cleanupWaitSeconds pass.
+func waitForLkeClusterDeleted(ctx context.Context, client *linodego.Client, lkeid int) error {
+	ctx, cancel := context.WithTimeout(ctx, cleanupWaitSeconds*time.Second)
+	defer cancel()
 
-		if err := client.DetachVolume(ctx, i); err != nil {
-			logger.Error("detach volume: " + err.Error())
+	ticker := time.NewTicker(cleanupPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			_, err := client.GetLKECluster(ctx, lkeid)
+			if linodego.IsNotFound(err) {
+				return nil
+			}
+
+			if err != nil {
+				return err
+			}
+		case <-ctx.Done():
+			return fmt.Errorf("wait for lke cluster %d to be deleted: %w", lkeid, ctx.Err())
 		}
+	}
+}
+
+// deleteVolumes detaches and deletes volumes, cleanupWorkers at a time.
+func deleteVolumes(ctx context.Context, client *linodego.Client, volumes []linodego.Volume, report *cleanupReport) {
+	var (
+		wg   sync.WaitGroup
+		done atomic.Int32
+	)
+
+	results := make([]cleanupResult, len(volumes))
+	sem := make(chan struct{}, cleanupWorkers)
 
-		time.Sleep(5 * time.Second)
+	for idx, vol := range volumes {
+		if cleanupDryRun {
+			results[idx] = cleanupResult{Kind: "volume", ID: vol.ID, Label: vol.Label, Action: "would delete"}
 
-		if err := client.DeleteVolume(ctx, i); err != nil {
-			logger.Error("delete volume: " + err.Error())
+			continue
 		}
+
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			res := cleanupResult{Kind: "volume", ID: vol.ID, Label: vol.Label}
+			res.Action, res.Reason = deleteVolume(ctx, client, vol.ID)
+			results[idx] = res
+
+			msg := fmt.Sprintf("(%d/%d) volume %s %s", done.Add(1), len(volumes), vol.Label, res.Action)
+			logger.Info(msg)
+		}()
 	}
 
-	// delete nodebalancers
-	deleteNodeBalancers(ctx, lkeid)
+	wg.Wait()
+
+	for _, i := range results {
+		report.add(i)
+	}
 }
 
-func deleteNodeBalancers(ctx context.Context, lkeid int) {
+// deleteVolume waits for a volume to be detached and free of running events
+// before deleting it. It returns the report action and reason.
+func deleteVolume(ctx context.Context, client *linodego.Client, id int) (string, string) {
+	vol, err := client.GetVolume(ctx, id)
+	if err != nil {
+		if linodego.IsNotFound(err) {
+			return "skipped", "already deleted"
+		}
+
+		return "failed", err.Error()
+	}
+
+	if !platformTagged(vol.Tags) {
+		return "skipped", "not tagged " + platformTag()
+	}
+
+	if vol.LinodeID != nil {
+		// a node that is being deleted detaches its volumes on its own, so
+		// a rejected detach is left to the wait below
+		if err := client.DetachVolume(ctx, id); err != nil && !linodego.ErrHasStatus(err, http.StatusBadRequest) {
+			return "failed", "detach: " + err.Error()
+		}
+
+		if _, err := client.WaitForVolumeLinodeID(ctx, id, nil, cleanupWaitSeconds); err != nil {
+			return "failed", "wait for detach: " + err.Error()
+		}
+	}
+
+	if err := client.WaitForResourceFree(ctx, linodego.EntityVolume, id, cleanupWaitSeconds); err != nil {
+		return "failed", err.Error()
+	}
+
+	if err := client.DeleteVolume(ctx, id); err != nil {
+		if linodego.IsNotFound(err) {
+			return "skipped", "already deleted"
+		}
+
+		return "failed", err.Error()
+	}
+
+	return "deleted", ""
+}
+
+// deleteNodeBalancers deletes the NodeBalancers of an LKE cluster, matched by
+// the exact lke<id> in their label or tags, and the NodeBalancer with nbid,
+// which the ingress service is annotated with.
+func deleteNodeBalancers(ctx context.Context, lkeid, nbid int, report *cleanupReport) {
 	client := NewLinodeClient()
-	label := fmt.Sprintf("lke%d", lkeid)
+	lkeTag := fmt.Sprintf("lke%d", lkeid)
 
 	nodebalancers, err := client.ListNodeBalancers(ctx, &linodego.ListOptions{})
 	if err != nil {
@@ -124,15 +433,96 @@ func deleteNodeBalancers(ctx context.Context, lkeid int) {
 	}
 
 	for _, i := range nodebalancers {
-		if strings.Contains(*i.Label, label) {
-			err := client.DeleteNodeBalancer(ctx, i.ID)
-			if err != nil {
-				logger.Error("delete nodebalancers: " + err.Error())
-			}
+		label := ""
+		if i.Label != nil {
+			label = *i.Label
+		}
+
+		id, ok := lkeLabelID(label)
+		if !(nbid != 0 && i.ID == nbid) && !(ok && id == lkeid) && !slices.Contains(i.Tags, lkeTag) {
+			continue
+		}
+
+		res := cleanupResult{Kind: "nodebalancer", ID: i.ID, Label: label}
+		res.Action, res.Reason = deleteNodeBalancer(ctx, &client, i)
+		report.add(res)
+	}
+}
+
+// deleteNodeBalancer deletes a matched NodeBalancer that is tagged for the
+// platform, by the CCM annotation of the infra stack or adopt. It returns the
+// report action and reason.
+func deleteNodeBalancer(ctx context.Context, client *linodego.Client, nb linodego.NodeBalancer) (string, string) {
+	if other := otherPlatformTag(nb.Tags); other != "" {
+		return "skipped", "tagged " + other
+	}
+
+	if !platformTagged(nb.Tags) {
+		return "skipped", "not tagged " + platformTag()
+	}
+
+	if cleanupDryRun {
+		return "would delete", ""
+	}
+
+	if err := client.DeleteNodeBalancer(ctx, nb.ID); err != nil {
+		if linodego.IsNotFound(err) {
+			return "skipped", "already deleted"
+		}
+
+		return "failed", err.Error()
+	}
+
+	return "deleted", ""
+}
+
+// deleteFirewalls deletes the node firewall of the platform, matched by its
+// <name>-fw label and the platform tag, when no devices are attached to it.
+func deleteFirewalls(ctx context.Context, client *linodego.Client, report *cleanupReport) {
+	filter := fmt.Sprintf(`{"label": %q}`, platform.Name+"-fw")
+
+	firewalls, err := client.ListFirewalls(ctx, linodego.NewListOptions(0, filter))
+	if err != nil {
+		logger.Error("list firewalls: " + err.Error())
+	}
+
+	for _, i := range firewalls {
+		res := cleanupResult{Kind: "firewall", ID: i.ID, Label: i.Label}
+		res.Action, res.Reason = deleteFirewall(ctx, client, i)
+		report.add(res)
+	}
+}
+
+// deleteFirewall deletes a platform firewall and returns the report action and
+// reason.
+func deleteFirewall(ctx context.Context, client *linodego.Client, fw linodego.Firewall) (string, string) {
+	if !platformTagged(fw.Tags) {
+		return "skipped", "not tagged " + platformTag()
+	}
+
+	// devices are only detached once the cluster is deleted
+	if cleanupDryRun {
+		return "would delete", ""
+	}
+
+	devices, err := client.ListFirewallDevices(ctx, fw.ID, &linodego.ListOptions{})
+	if err != nil {
+		return "failed", "list devices: " + err.Error()
+	}
+
+	if len(devices) > 0 {
+		return "skipped", fmt.Sprintf("%d devices attached", len(devices))
+	}
+
+	if err := client.DeleteFirewall(ctx, fw.ID); err != nil {
+		if linodego.IsNotFound(err) {
+			return "skipped", "already deleted"
 		}
+
+		return "failed", err.Error()
 	}
 
-	logger.Info("purged nodebalancers")
+	return "deleted", ""
 }
 
 func NewLinodeClient() linodego.Client {
@@ -141,6 +531,11 @@ func NewLinodeClient() linodego.Client {
 		logger.Error("new linode client: could not find LINODE_TOKEN")
 	}
 
+	return newLinodeClient(apikey)
+}
+
+// newLinodeClient returns a client authenticated with the given token.
+func newLinodeClient(apikey string) linodego.Client {
 	tokenSource := oauth2.StaticTokenSource(&oauth2.Token{AccessToken: apikey})
 	oauth2Client := &http.Client{
 		Transport: &oauth2.Transport{
@@ -1,9 +1,15 @@
 package cmd
 
 import (
+	"cmp"
 	"context"
+	"errors"
+	"fmt"
+	"net"
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
 	"time"
 
 	"github.com/spf13/cobra"
@@ -11,84 +17,74 @@ import (
 )
 
 type Platform struct {
-	Email       string   `yaml:"email,omitempty"`
-	Domain      string   `yaml:"domain,omitempty"`
-	AplVersion  string   `yaml:"aplversion,omitempty"`
-	KubeVersion string   `yaml:"kubeversion,omitempty"`
-	Name        string   `yaml:"name,omitempty"`
-	NbTag       string   `yaml:"nbtag,omitempty"`
-	NodeCount   int      `yaml:"nodecount,omitempty"`
-	NodeMax     int      `yaml:"nodemax,omitempty"`
-	NodeType    string   `yaml:"nodetype,omitempty"`
-	ObjPrefix   string   `yaml:"objprefix,omitempty"`
-	Region      string   `yaml:"region,omitempty"`
-	Repo        string   `yaml:"repo,omitempty"`
-	Stack       string   `yaml:"stack,omitempty"`
-	Tags        []string `yaml:"tags,omitempty"`
-	Values      string   `yaml:"values,omitempty"`
+	Email           string                     `yaml:"email,omitempty"`
+	Domain          string                     `yaml:"domain,omitempty"`
+	ControlPlaneAcl ControlPlaneAcl            `yaml:"controlplaneacl,omitempty"`
+	Firewall        FirewallConfig             `yaml:"firewall,omitempty"`
+	AgeKeyFile      string                     `yaml:"agekeyfile,omitempty"`
+	AplVersion      string                     `yaml:"aplversion,omitempty"`
+	KubeVersion     string                     `yaml:"kubeversion,omitempty"`
+	Lifecycle       map[string]BucketLifecycle `yaml:"lifecycle,omitempty"`
+	LkeTier         string                     `yaml:"lketier,omitempty"`
+	Name            string                     `yaml:"name,omitempty"`
+	NbTag           string                     `yaml:"nbtag,omitempty"`
+	NodeCount       int                        `yaml:"nodecount,omitempty"`
+	NodeMax         int                        `yaml:"nodemax,omitempty"`
+	NodePools       []NodePoolConfig           `yaml:"nodepools,omitempty"`
+	NodeType        string                     `yaml:"nodetype,omitempty"`
+	ObjPrefix       string                     `yaml:"objprefix,omitempty"`
+	Region          string                     `yaml:"region,omitempty"`
+	Repo            string                     `yaml:"repo,omitempty"`
+	SecretStore     string                     `yaml:"secretstore,omitempty"`
+	SharedEnv       string                     `yaml:"sharedenv,omitempty"`
+	Stack           string                     `yaml:"stack,omitempty"`
+	Tags            []string                   `yaml:"tags,omitempty"`
+	Values          string                     `yaml:"values,omitempty"`
+	VaultAddr       string                     `yaml:"vaultaddr,omitempty"`
+	VaultMount      string                     `yaml:"vaultmount,omitempty"`
+	Vpc             VpcConfig                  `yaml:"vpc,omitempty"`
 }
 
+// VpcConfig attaches the LKE cluster to a VPC subnet, either a dedicated
+// <name>-vpc created by the infra stack or an existing VPC found by label.
+// Only the enterprise tier attaches a cluster to a custom VPC. With backends
+// set, NodeBalancers reach their backends on VPC addresses; the nodes keep
+// their public IPs either way.
+type VpcConfig struct {
+	BackendRange string `yaml:"backendrange,omitempty"`
+	Backends     bool   `yaml:"backends,omitempty"`
+	Dedicated    bool   `yaml:"dedicated,omitempty"`
+	Label        string `yaml:"label,omitempty"`
+	Subnet       string `yaml:"subnet,omitempty"`
+	SubnetLabel  string `yaml:"subnetlabel,omitempty"`
+}
+
+const (
+	defaultAplVersion  = "4.12.1"
+	defaultKubeVersion = "1.33"
+)
+
 var platform Platform
 
+var lkeTiers = []string{"standard", "enterprise"}
+
+// vpcTiers are the LKE tiers that attach a cluster to a custom VPC
+var vpcTiers = []string{"enterprise"}
+
 var createCmd = &cobra.Command{
 	Use:   "create",
 	Short: "Create and bootstrap App Platform projects",
+	PreRun: func(cmd *cobra.Command, args []string) {
+		if err := platform.validateNetwork(); err != nil {
+			logger.Error("create: " + err.Error())
+			os.Exit(1)
+		}
+	},
 	Run: func(cmd *cobra.Command, args []string) {
 		ctx, cancel := context.WithTimeout(context.Background(), 1*time.Minute)
 		defer cancel()
 
-		projPath := filepath.Join(paths.Projects, platform.Name)
-		values := filepath.Join(paths.Values, platform.Values)
-		proj := NewProject(platform, projPath, values)
-
-		// codegen
-		proj.CodeGen()
-		proj.Init(ctx)
-
-		// initialize pulumi esc environment and generate admin passwords
-		secMap := map[string]any{
-			"developTeamPass": FnSecret(Passgen()),
-			"lokiAdminPass":   FnSecret(Passgen()),
-			"otomiAdminPass":  FnSecret(Passgen()),
-		}
-
-		// generate age provider sops keys
-		ageKeys, err := GenAgeKeys()
-		if err != nil {
-			logger.Error("generate age keys: " + err.Error())
-		}
-
-		ageKeyMap := map[string]any{
-			"publicKey":  ageKeys.Recipient().String(),
-			"privateKey": FnSecret(ageKeys.String()),
-		}
-
-		token := map[string]any{
-			"token": FnSecret(os.Getenv("LINODE_TOKEN")),
-		}
-
-		// ordered map of esc values to write
-		escItems := map[int]EscEnvItem{
-			1: {Name: "linode", Value: token},
-			2: {Name: "age", Value: ageKeyMap},
-			3: {Name: "aplSecrets", Value: secMap},
-		}
-
-		// pulumiConfig: https://tinyurl.com/pulumiconfig-esc
-		escConfig := map[string]any{
-			"linode:token": `${linode.token}`,
-			"apl:age":      `${age}`,
-			"apl:secrets":  `${aplSecrets}`,
-		}
-
-		esc := EscEnv{
-			EnvName:  platform.Stack,
-			Config:   escConfig,
-			Items:    escItems,
-			OrgName:  viper.GetString("pulumiOrg"),
-			ProjName: platform.Name,
-		}
-		esc.Init()
+		createPlatform(ctx)
 	},
 }
 
@@ -107,13 +103,16 @@ func init() {
 	createCmd.Flags().StringVarP(&platform.Region, "region", "r", "", "Akamai cloud region (required)")
 
 	// optional local flags
-	createCmd.Flags().StringVarP(&platform.AplVersion, "apl-version", "", "4.12.1", "App Platform version")
-	createCmd.Flags().StringVarP(&platform.KubeVersion, "kube-version", "", "1.33", "Kubernetes version")
+	createCmd.Flags().StringVarP(&platform.AplVersion, "apl-version", "", defaultAplVersion, "App Platform version")
+	createCmd.Flags().StringVarP(&platform.KubeVersion, "kube-version", "", defaultKubeVersion, "Kubernetes version")
+	createCmd.Flags().StringVarP(&platform.LkeTier, "lke-tier", "", "standard", "LKE tier: standard or enterprise")
 	createCmd.Flags().StringVarP(&platform.ObjPrefix, "obj-prefix", "", "apl", "S3 bucket label prefix")
 	createCmd.Flags().StringVarP(&platform.NbTag, "nb-tag", "", "apl-static-lb", "NodeBalancer tag")
 	createCmd.Flags().IntVarP(&platform.NodeCount, "node-count", "", 3, "Node pool count")
 	createCmd.Flags().IntVarP(&platform.NodeMax, "node-max", "", 15, "Node pool autoscale max")
 	createCmd.Flags().StringVarP(&platform.NodeType, "node-type", "", "g6-dedicated-8", "Node pool instance type")
+	createCmd.Flags().StringVarP(&platform.SharedEnv, "shared-env", "", "",
+		"Org-level ESC environment imported for shared values, such as "+defaultSharedEnv)
 	createCmd.Flags().StringVarP(&platform.Stack, "stack", "", "dev", "Pulumi stack name")
 	createCmd.Flags().StringArrayVarP(&platform.Tags, "tags", "", defaultTags, "Cloud infra tags")
 	createCmd.Flags().StringVarP(&platform.Repo, "repo", "", defaultRepo, "Repo URL")
@@ -121,3 +120,126 @@ func init() {
 
 	_ = viper.BindPFlags(createCmd.LocalFlags())
 }
+
+// validateNetwork checks the LKE tier and VPC options of the platform config.
+func (p Platform) validateNetwork() error {
+	if p.LkeTier != "" && !slices.Contains(lkeTiers, p.LkeTier) {
+		return fmt.Errorf("lke tier %q is not one of %s", p.LkeTier, strings.Join(lkeTiers, ", "))
+	}
+
+	v := p.Vpc
+
+	switch {
+	case v != VpcConfig{} && !slices.Contains(vpcTiers, cmp.Or(p.LkeTier, "standard")):
+		return fmt.Errorf("vpc: lke tier %q does not attach clusters to a custom vpc, use %s",
+			cmp.Or(p.LkeTier, "standard"), strings.Join(vpcTiers, ", "))
+	case v.Dedicated && v.Label != "":
+		return errors.New("vpc: set either dedicated or the label of an existing vpc")
+	case v.Label != "" && v.SubnetLabel == "":
+		return errors.New("vpc " + v.Label + ": subnetLabel is required")
+	case v.Backends && !v.Dedicated && v.Label == "":
+		return errors.New("vpc: backends needs a dedicated or existing vpc")
+	}
+
+	for _, i := range []string{v.Subnet, v.BackendRange} {
+		if _, _, err := net.ParseCIDR(i); i != "" && err != nil {
+			return fmt.Errorf("vpc: %q is not an ipv4 range", i)
+		}
+	}
+
+	return nil
+}
+
+// createPlatform generates the project code for the running platform config
+// and initializes its ESC environment with generated secrets.
+func createPlatform(ctx context.Context) {
+	projPath := filepath.Join(paths.Projects, platform.Name)
+	values := filepath.Join(paths.Values, platform.Values)
+	proj := NewProject(platform, projPath, values)
+
+	// codegen
+	proj.CodeGen()
+	proj.Init(ctx)
+
+	// initialize pulumi esc environment and generate admin passwords
+	secMap := map[string]any{
+		"developTeamPass": FnSecret(Passgen()),
+		"lokiAdminPass":   FnSecret(Passgen()),
+		"otomiAdminPass":  FnSecret(Passgen()),
+	}
+
+	// generate age provider sops keys
+	ageKeys, err := GenAgeKeys()
+	if err != nil {
+		logger.Error("generate age keys: " + err.Error())
+	}
+
+	ageKeyMap := map[string]any{
+		"publicKey":  ageKeys.Recipient().String(),
+		"privateKey": FnSecret(ageKeys.String()),
+	}
+
+	token := map[string]any{
+		"token": FnSecret(os.Getenv("LINODE_TOKEN")),
+	}
+
+	// ordered map of esc values to write
+	escItems := map[int]EscEnvItem{
+		1: {Name: "linode", Value: token},
+		2: {Name: "age", Value: ageKeyMap},
+		3: {Name: "aplSecrets", Value: secMap},
+	}
+
+	// pulumiConfig: https://tinyurl.com/pulumiconfig-esc
+	escConfig := map[string]any{
+		"linode:token": `${linode.token}`,
+		"apl:age":      `${age}`,
+		"apl:secrets":  `${aplSecrets}`,
+	}
+
+	esc := EscEnv{
+		Confirm:  true,
+		EnvName:  platform.Stack,
+		Config:   escConfig,
+		Items:    escItems,
+		OrgName:  viper.GetString("pulumiOrg"),
+		ProjName: platform.Name,
+	}
+
+	// shared values such as the linode token live in one org-level environment
+	// imported by every platform that opts in, so rotating them updates a
+	// single place
+	if _, ok := platformStore().(*escStore); ok && platform.SharedEnv != "" && confirmSharedEnv(esc.OrgName) {
+		shared, own := splitSharedItems(escItems)
+
+		if err := initSharedEnv(esc.OrgName, platform.SharedEnv, shared); err != nil {
+			logger.Error("initialize shared esc environment: " + err.Error())
+		} else {
+			esc.Items = own
+			esc.Imports = []string{platform.SharedEnv}
+		}
+	}
+
+	if err := platformStore().Init(&esc); err != nil {
+		logger.Error("initialize secret store: " + err.Error())
+	}
+}
+
+// confirmSharedEnv asks before a new platform imports an existing shared
+// environment, since the platform then runs with the credentials it holds.
+func confirmSharedEnv(org string) bool {
+	proj, name, ok := splitEnvRef(platform.SharedEnv)
+	if !ok || !EscExists(org, proj, name) {
+		return true
+	}
+
+	prompt := "import existing shared esc environment " + platform.SharedEnv +
+		" and use its values, such as the linode token? (type YES to confirm)"
+	if ok := InputPrompt("warn", "YES", prompt); !ok {
+		logger.Warn("shared esc environment not imported: values are kept in the platform environment")
+
+		return false
+	}
+
+	return true
+}
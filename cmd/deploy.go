@@ -15,11 +15,8 @@ var deployCmd = &cobra.Command{
 	Use:   "deploy",
 	Short: "Deploy an App Platform project",
 	PreRun: func(cmd *cobra.Command, args []string) {
-		org := viper.GetString("PulumiOrg")
-		ok := EscExists(org, platform.Name, platform.Stack)
-
-		if !ok {
-			logger.Error("esc environment not found: run 'create' command first")
+		if ok := platformStore().Exists(); !ok {
+			logger.Error("secret store not found: run 'create' command first")
 			os.Exit(0)
 		}
 	},
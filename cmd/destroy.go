@@ -9,6 +9,8 @@ import (
 )
 
 var (
+	archiveObjDst string
+	destroyDryRun bool
 	destroyStacks StackMap
 	destroyTarget string
 	purgeAll      bool
@@ -46,6 +48,11 @@ var destroyCmd = &cobra.Command{
 			}
 		}
 
+		// a dry run only lists what cleanup would delete
+		if destroyDryRun {
+			return
+		}
+
 		if purgeAll {
 			purgeObj = true
 			purgeEsc = true
@@ -79,6 +86,22 @@ var destroyCmd = &cobra.Command{
 			}
 		}
 
+		if destroyDryRun {
+			cleanupDryRun = true
+			s := initLocalStack(ctx, destroyStacks[2])
+
+			cleanupLke(ctx, s)
+			cleanupNodeBalancers(ctx, s)
+
+			return
+		}
+
+		// archive runs before any stack is destroyed, so a failed copy stops
+		// destroy while the platform and its buckets are intact
+		if archiveObjDst != "" && destroyTarget != "apl" {
+			archiveObj(ctx, initLocalStack(ctx, destroyStacks[2]))
+		}
+
 		switch {
 		case idx > 0:
 			if st, ok := destroyStacks[idx]; ok {
@@ -93,10 +116,23 @@ var destroyCmd = &cobra.Command{
 		}
 
 		if purgeEsc {
-			org := viper.GetString("pulumiOrg")
-			esc := NewEnvObject(org, platform.Name, platform.Stack)
+			// the environment holds the only copy of the generated passwords
+			// and age key, so it is not purged without a backup
+			if _, ok := platformStore().(*escStore); ok {
+				file, err := backupEnv("")
+				if err != nil {
+					logger.Error("backup esc environment before purge: " + err.Error())
+					logger.Warn("esc environment not purged")
+
+					return
+				}
 
-			esc.Remove()
+				logger.Info("esc environment backed up to " + file)
+			}
+
+			if err := platformStore().Remove(); err != nil {
+				logger.Error("purge secret store: " + err.Error())
+			}
 		}
 	},
 }
@@ -106,9 +142,13 @@ func init() {
 	destroyCmd.Flags().StringVarP(&platform.Name, "name", "n", "", "APL instance name (required)")
 	destroyCmd.MarkFlagRequired("name") //nolint:errcheck
 	// optional flags
+	destroyCmd.Flags().StringVarP(&archiveObjDst, "archive-obj", "", "",
+		"Copy APL buckets to an rclone remote:path or local directory before destroy")
+	destroyCmd.Flags().BoolVarP(&destroyDryRun, "dry-run", "", false,
+		"List the cloud resources cleanup would delete, without destroying anything")
 	destroyCmd.Flags().StringVarP(&destroyTarget, "target", "t", "", "Target a specific project")
 	destroyCmd.Flags().BoolVarP(&purgeAll, "purge", "", false, "Purge all infrastructure and Pulumi resources")
-	destroyCmd.Flags().BoolVarP(&purgeEsc, "purge-esc", "", false, "Purge Pulumi ESC environment")
+	destroyCmd.Flags().BoolVarP(&purgeEsc, "purge-esc", "", false, "Purge Pulumi ESC environment (backed up first)")
 	destroyCmd.Flags().BoolVarP(&purgeObj, "purge-obj", "", false, "Purge objects in APL buckets")
 	destroyCmd.Flags().BoolVarP(&purgeStk, "purge-stack", "", false, "Purge Pulumi stack data")
 
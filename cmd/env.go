@@ -0,0 +1,650 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"os/exec"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	esc "github.com/pulumi/esc-sdk/sdk/go"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// envChange is a single changed leaf of an esc environment definition. Op is
+// one of "+" (added), "-" (removed) or "~" (modified).
+type envChange struct {
+	Op  string
+	Key string
+	Old string
+	New string
+}
+
+var envCmd = &cobra.Command{
+	Use:   "env",
+	Short: "Inspect and manage the platform Pulumi ESC environment",
+}
+
+var envHistoryCmd = &cobra.Command{
+	Use:    "history",
+	Short:  "List revisions of the platform ESC environment",
+	Args:   cobra.NoArgs,
+	PreRun: escStoreOnly,
+	Run: func(cmd *cobra.Command, args []string) {
+		org := viper.GetString("pulumiOrg")
+
+		authCtx, escClient, err := esc.DefaultLogin()
+		if err != nil {
+			logger.Error("pulumi esc history login: " + err.Error())
+
+			return
+		}
+
+		revs, err := escClient.ListEnvironmentRevisions(authCtx, org, platform.Name, platform.Stack)
+		if err != nil {
+			logger.Error("list esc environment revisions: " + err.Error())
+
+			return
+		}
+
+		logger.Info("header:" + fmt.Sprintf("%s/%s/%s", org, platform.Name, platform.Stack))
+
+		for _, i := range revs {
+			line := fmt.Sprintf("%-6d %-26s %-20s %s",
+				i.Number, i.GetCreated(), i.GetCreatorLogin(), strings.Join(i.Tags, ","))
+			logger.Info("line:" + line)
+		}
+
+		fmt.Println() //nolint:forbidigo
+
+		msg := fmt.Sprintf("%d revisions found", len(revs))
+		logger.Info(msg)
+	},
+}
+
+var envDiffCmd = &cobra.Command{
+	Use:    "diff <rev1> <rev2>",
+	Short:  "Show changes between two ESC environment revisions",
+	Args:   cobra.ExactArgs(2),
+	PreRun: escStoreOnly,
+	Run: func(cmd *cobra.Command, args []string) {
+		org := viper.GetString("pulumiOrg")
+
+		authCtx, escClient, err := esc.DefaultLogin()
+		if err != nil {
+			logger.Error("pulumi esc diff login: " + err.Error())
+
+			return
+		}
+
+		defs := make([]*esc.EnvironmentDefinition, 0, 2)
+
+		for _, rev := range args {
+			def, _, err := escClient.GetEnvironmentAtVersion(authCtx, org, platform.Name, platform.Stack, rev)
+			if err != nil {
+				logger.Error("get esc environment revision " + rev + ": " + err.Error())
+
+				return
+			}
+
+			defs = append(defs, def)
+		}
+
+		changes := diffEnv(envDefinitionMap(defs[0]), envDefinitionMap(defs[1]))
+
+		logger.Info("header:" + fmt.Sprintf("revision %s -> %s", args[0], args[1]))
+		printEnvChanges(changes)
+	},
+}
+
+var envRollbackCmd = &cobra.Command{
+	Use:    "rollback <rev>",
+	Short:  "Restore the ESC environment definition of a previous revision",
+	Args:   cobra.ExactArgs(1),
+	PreRun: escStoreOnly,
+	Run: func(cmd *cobra.Command, args []string) {
+		org := viper.GetString("pulumiOrg")
+		rev := args[0]
+
+		authCtx, escClient, err := esc.DefaultLogin()
+		if err != nil {
+			logger.Error("pulumi esc rollback login: " + err.Error())
+
+			return
+		}
+
+		cur, _, err := escClient.GetEnvironment(authCtx, org, platform.Name, platform.Stack)
+		if err != nil {
+			logger.Error("get existing esc environment: " + err.Error())
+
+			return
+		}
+
+		// the raw yaml keeps secrets as ciphertext, so it can be written back
+		// as is without decrypting them
+		def, yaml, err := escClient.GetEnvironmentAtVersion(authCtx, org, platform.Name, platform.Stack, rev)
+		if err != nil {
+			logger.Error("get esc environment revision " + rev + ": " + err.Error())
+
+			return
+		}
+
+		changes := diffEnv(envDefinitionMap(cur), envDefinitionMap(def))
+		if len(changes) == 0 {
+			logger.Info("esc environment already matches revision " + rev)
+
+			return
+		}
+
+		logger.Info("header:" + fmt.Sprintf("current -> revision %s", rev))
+		printEnvChanges(changes)
+
+		prompt := fmt.Sprintf("roll back esc environment to revision %s? (type YES to confirm)", rev)
+		if ok := InputPrompt("warn", "YES", prompt); !ok {
+			logger.Warn("rollback cancelled")
+
+			return
+		}
+
+		if _, err := escClient.UpdateEnvironmentYaml(authCtx, org, platform.Name, platform.Stack, yaml); err != nil {
+			logger.Error("write esc environment revision " + rev + ": " + err.Error())
+
+			return
+		}
+
+		logger.Info("esc environment rolled back to revision " + rev)
+	},
+}
+
+var (
+	envOpenFormat string
+	envShareRef   string
+)
+
+var envOpenCmd = &cobra.Command{
+	Use:    "open",
+	Short:  "Print the resolved ESC values as environment variables",
+	Args:   cobra.NoArgs,
+	PreRun: escStoreOnly,
+	Run: func(cmd *cobra.Command, args []string) {
+		vars, err := openEnvVars()
+		if err != nil {
+			logger.Error("open esc environment: " + err.Error())
+
+			return
+		}
+
+		out, err := formatEnvVars(vars, envOpenFormat)
+		if err != nil {
+			logger.Error("env open: " + err.Error())
+
+			return
+		}
+
+		fmt.Print(out) //nolint:forbidigo
+	},
+}
+
+var envRunCmd = &cobra.Command{
+	Use:    "run -- <cmd> [args...]",
+	Short:  "Run a command with the resolved ESC values as environment variables",
+	Args:   cobra.MinimumNArgs(1),
+	PreRun: escStoreOnly,
+	Run: func(cmd *cobra.Command, args []string) {
+		vars, err := openEnvVars()
+		if err != nil {
+			logger.Error("open esc environment: " + err.Error())
+
+			return
+		}
+
+		// values only exist in the environment of the subprocess
+		c := exec.CommandContext(cmd.Context(), args[0], args[1:]...) //nolint:gosec
+		c.Env = os.Environ()
+		c.Stdin, c.Stdout, c.Stderr = os.Stdin, os.Stdout, os.Stderr
+
+		for _, k := range slices.Sorted(maps.Keys(vars)) {
+			c.Env = append(c.Env, k+"="+vars[k])
+		}
+
+		if err := c.Run(); err != nil {
+			var exitErr *exec.ExitError
+			if errors.As(err, &exitErr) {
+				os.Exit(exitErr.ExitCode())
+			}
+
+			logger.Error("env run: " + err.Error())
+			os.Exit(1)
+		}
+	},
+}
+
+var envShareCmd = &cobra.Command{
+	Use:    "share",
+	Short:  "Move shared values to the org-level ESC environment and import it",
+	Args:   cobra.NoArgs,
+	PreRun: escStoreOnly,
+	Run: func(cmd *cobra.Command, args []string) {
+		org := viper.GetString("pulumiOrg")
+
+		ref := envShareRef
+		if !cmd.Flags().Changed("shared-env") && platform.SharedEnv != "" {
+			ref = platform.SharedEnv
+		}
+
+		if _, _, ok := splitEnvRef(ref); !ok {
+			logger.Error("env share: --shared-env wants <project>/<environment>")
+
+			return
+		}
+
+		authCtx, escClient, err := esc.DefaultLogin()
+		if err != nil {
+			logger.Error("pulumi esc share login: " + err.Error())
+
+			return
+		}
+
+		cur, etag, err := getEnvironment(authCtx, escClient, org, platform.Name, platform.Stack)
+		if err != nil {
+			logger.Error("get existing esc environment: " + err.Error())
+
+			return
+		}
+
+		if slices.Contains(cur.Imports, ref) {
+			logger.Info("esc environment already imports " + ref)
+
+			return
+		}
+
+		// seed a new shared environment with the resolved values of this
+		// platform, secrets are encrypted again for the shared environment
+		_, resolved, err := escClient.OpenAndReadEnvironment(authCtx, org, platform.Name, platform.Stack)
+		if err != nil {
+			logger.Error("open esc environment: " + err.Error())
+
+			return
+		}
+
+		values := maps.Clone(cur.GetValues().AdditionalProperties)
+		items := make(map[int]EscEnvItem)
+
+		for idx, i := range sharedEnvItems {
+			if v, ok := values[i]; ok {
+				items[idx] = EscEnvItem{Name: i, Value: reSecret(v, resolved[i])}
+				delete(values, i)
+			}
+		}
+
+		next := &esc.EnvironmentDefinition{
+			Imports: append(slices.Clone(cur.Imports), ref),
+			Values: &esc.EnvironmentDefinitionValues{
+				PulumiConfig:         cur.GetValues().PulumiConfig,
+				AdditionalProperties: values,
+			},
+		}
+
+		logger.Info("header:" + fmt.Sprintf("%s/%s/%s changes", org, platform.Name, platform.Stack))
+		printEnvChanges(diffEnv(envDefinitionMap(cur), envDefinitionMap(next)))
+
+		prompt := fmt.Sprintf("import %s and remove the local shared values? (type YES to confirm)", ref)
+		if ok := InputPrompt("warn", "YES", prompt); !ok {
+			logger.Warn("share cancelled")
+
+			return
+		}
+
+		if err := initSharedEnv(org, ref, items); err != nil {
+			logger.Error("initialize shared esc environment: " + err.Error())
+
+			return
+		}
+
+		env := NewEnvObject(org, platform.Name, platform.Stack)
+		env.Config = next.Values.PulumiConfig
+		env.Imports = next.Imports
+
+		if err := env.writeIfMatch(authCtx, values, etag); err != nil {
+			logger.Error("write esc environment: " + err.Error())
+
+			return
+		}
+
+		logger.Info("esc environment imports " + ref)
+	},
+}
+
+func init() {
+	// required flags
+	envCmd.PersistentFlags().StringVarP(&platform.Name, "name", "n", "", "APL instance name (required)")
+	envCmd.MarkPersistentFlagRequired("name") //nolint:errcheck
+
+	// optional flags
+	envOpenCmd.Flags().StringVarP(&envOpenFormat, "format", "f", "dotenv", "Output format: dotenv|json|shell")
+	envShareCmd.Flags().StringVarP(&envShareRef, "shared-env", "", defaultSharedEnv,
+		"Org-level ESC environment to import, as <project>/<environment>")
+
+	envCmd.AddCommand(envBackupCmd, envDiffCmd, envHistoryCmd, envOpenCmd, envRestoreCmd, envRollbackCmd,
+		envRunCmd, envShareCmd)
+}
+
+// escStoreOnly stops env commands for platforms using a secret store without
+// revision history.
+func escStoreOnly(cmd *cobra.Command, args []string) {
+	if _, ok := platformStore().(*escStore); !ok {
+		logger.Error("env " + cmd.Name() + ": requires the esc secret store")
+		os.Exit(1)
+	}
+}
+
+// openEnvVars opens the platform environment and maps each resolved value to
+// an environment variable, such as objKey.accessKey to OBJ_KEY_ACCESS_KEY.
+// Entries of the esc environmentVariables map are used as is and win over
+// converted names. pulumiConfig only references other values and is skipped.
+func openEnvVars() (map[string]string, error) {
+	authCtx, escClient, err := esc.DefaultLogin()
+	if err != nil {
+		return nil, err
+	}
+
+	org := viper.GetString("pulumiOrg")
+
+	_, values, err := escClient.OpenAndReadEnvironment(authCtx, org, platform.Name, platform.Stack)
+	if err != nil {
+		return nil, err
+	}
+
+	envVars, _ := values["environmentVariables"].(map[string]any)
+
+	delete(values, "environmentVariables")
+	delete(values, "files")
+	delete(values, "pulumiConfig")
+
+	leaves := make(map[string]string)
+	flattenValues("", values, leaves)
+
+	vars := make(map[string]string, len(leaves)+len(envVars))
+	for k, v := range leaves {
+		vars[envVarName(k)] = v
+	}
+
+	for k, v := range envVars {
+		vars[k] = fmt.Sprint(v)
+	}
+
+	return vars, nil
+}
+
+// flattenValues walks resolved environment values and records each leaf under
+// a dotted key as its plain string, unlike flattenEnv, which renders leaves
+// for display.
+func flattenValues(prefix string, v any, out map[string]string) {
+	join := func(k string) string {
+		if prefix == "" {
+			return k
+		}
+
+		return prefix + "." + k
+	}
+
+	switch val := v.(type) {
+	case map[string]any:
+		for k, i := range val {
+			flattenValues(join(k), i, out)
+		}
+	case []any:
+		for idx, i := range val {
+			flattenValues(join(strconv.Itoa(idx)), i, out)
+		}
+	case nil:
+		out[prefix] = ""
+	case float64:
+		// json numbers, written without an exponent
+		out[prefix] = strconv.FormatFloat(val, 'f', -1, 64)
+	default:
+		out[prefix] = fmt.Sprint(val)
+	}
+}
+
+// envVarName converts a dotted camelCase key to an upper snake case name.
+func envVarName(key string) string {
+	var b strings.Builder
+
+	prev := rune(0)
+
+	for _, r := range key {
+		switch {
+		case unicode.IsUpper(r) && (unicode.IsLower(prev) || unicode.IsDigit(prev)):
+			b.WriteRune('_')
+			b.WriteRune(r)
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			b.WriteRune(unicode.ToUpper(r))
+		default:
+			// separators such as dots become underscores
+			r = '_'
+			b.WriteRune(r)
+		}
+
+		prev = r
+	}
+
+	return b.String()
+}
+
+// formatEnvVars renders environment variables sorted by name as a dotenv
+// file, a json object or shell export statements.
+func formatEnvVars(vars map[string]string, format string) (string, error) {
+	var b strings.Builder
+
+	keys := slices.Sorted(maps.Keys(vars))
+
+	switch format {
+	case "dotenv":
+		for _, k := range keys {
+			fmt.Fprintf(&b, "%s=%s\n", k, strconv.Quote(vars[k]))
+		}
+	case "shell":
+		for _, k := range keys {
+			v := strings.ReplaceAll(vars[k], "'", `'\''`)
+			fmt.Fprintf(&b, "export %s='%s'\n", k, v)
+		}
+	case "json":
+		data, err := json.MarshalIndent(vars, "", "  ")
+		if err != nil {
+			return "", err
+		}
+
+		b.Write(data)
+		b.WriteString("\n")
+	default:
+		return "", errors.New("--format must be one of dotenv, json, shell")
+	}
+
+	return b.String(), nil
+}
+
+// reSecret rebuilds a definition value from its resolved plaintext, wrapping
+// the leaves that are secrets in the definition in fn::secret.
+func reSecret(def, resolved any) any {
+	m, ok := def.(map[string]any)
+	if !ok {
+		return resolved
+	}
+
+	if _, ok := m["fn::secret"]; ok && len(m) == 1 {
+		return FnSecret(fmt.Sprint(resolved))
+	}
+
+	r, _ := resolved.(map[string]any)
+	out := make(map[string]any, len(m))
+
+	for k, v := range m {
+		out[k] = reSecret(v, r[k])
+	}
+
+	return out
+}
+
+// envDefinitionMap converts an environment definition to a generic map so
+// that revisions and pending writes can be diffed the same way.
+func envDefinitionMap(def *esc.EnvironmentDefinition) map[string]any {
+	m := make(map[string]any)
+	if def == nil {
+		return m
+	}
+
+	if len(def.Imports) > 0 {
+		m["imports"] = def.Imports
+	}
+
+	if values, err := def.GetValues().ToMap(); err == nil {
+		m["values"] = values
+	}
+
+	return m
+}
+
+// diffEnv compares two environment maps leaf by leaf and returns the changes
+// sorted by key. Secret values are masked.
+func diffEnv(old, new map[string]any) []envChange {
+	a := make(map[string]string)
+	b := make(map[string]string)
+
+	flattenEnv("", old, a)
+	flattenEnv("", new, b)
+
+	return diffEnvLeaves(a, b)
+}
+
+func diffEnvLeaves(a, b map[string]string) []envChange {
+	changes := make([]envChange, 0)
+
+	for k, v := range a {
+		nv, ok := b[k]
+
+		switch {
+		case !ok:
+			changes = append(changes, envChange{Op: "-", Key: k, Old: v})
+		case nv != v:
+			changes = append(changes, envChange{Op: "~", Key: k, Old: v, New: nv})
+		}
+	}
+
+	for k, v := range b {
+		if _, ok := a[k]; !ok {
+			changes = append(changes, envChange{Op: "+", Key: k, New: v})
+		}
+	}
+
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Key < changes[j].Key
+	})
+
+	return changes
+}
+
+// flattenEnv walks a nested value and records each leaf under a dotted key.
+// A "fn::secret" map is a leaf: stored ciphertext renders as [secret] plus a
+// short fingerprint, while a plaintext secret about to be written renders as
+// [secret:new].
+func flattenEnv(prefix string, v any, out map[string]string) {
+	join := func(k string) string {
+		if prefix == "" {
+			return k
+		}
+
+		return prefix + "." + k
+	}
+
+	switch val := v.(type) {
+	case map[string]any:
+		if s, ok := val["fn::secret"]; ok && len(val) == 1 {
+			out[prefix] = maskSecret(s)
+
+			return
+		}
+
+		for k, i := range val {
+			flattenEnv(join(k), i, out)
+		}
+	case map[string]string:
+		if s, ok := val["fn::secret"]; ok && len(val) == 1 {
+			out[prefix] = maskSecret(s)
+
+			return
+		}
+
+		for k, i := range val {
+			out[join(k)] = i
+		}
+	case map[string]int:
+		for k, i := range val {
+			out[join(k)] = strconv.Itoa(i)
+		}
+	case []any:
+		for idx, i := range val {
+			flattenEnv(join(strconv.Itoa(idx)), i, out)
+		}
+	case []string:
+		out[prefix] = strings.Join(val, ",")
+	default:
+		b, err := json.Marshal(val)
+		if err != nil {
+			out[prefix] = fmt.Sprint(val)
+
+			return
+		}
+
+		out[prefix] = strings.Trim(string(b), `"`)
+	}
+}
+
+func maskSecret(s any) string {
+	switch v := s.(type) {
+	case string:
+		return "[secret:new]"
+	case map[string]any:
+		if c, ok := v["ciphertext"].(string); ok && len(c) > 8 {
+			return "[secret:" + c[len(c)-8:] + "]"
+		}
+	}
+
+	return "[secret]"
+}
+
+func printEnvChanges(changes []envChange) {
+	if len(changes) == 0 {
+		logger.Info("line:no changes")
+		fmt.Println() //nolint:forbidigo
+
+		return
+	}
+
+	for _, i := range changes {
+		var line string
+
+		switch i.Op {
+		case "+":
+			line = fmt.Sprintf("%s+ %s: %s%s", Green, i.Key, i.New, Reset)
+		case "-":
+			line = fmt.Sprintf("%s- %s: %s%s", Red, i.Key, i.Old, Reset)
+		default:
+			line = fmt.Sprintf("%s~ %s: %s -> %s%s", Yellow, i.Key, i.Old, i.New, Reset)
+		}
+
+		logger.Info("line:" + line)
+	}
+
+	fmt.Println() //nolint:forbidigo
+
+	msg := fmt.Sprintf("%d changed keys", len(changes))
+	logger.Info(msg)
+}
@@ -1,21 +1,45 @@
 package cmd
 
 import (
+	"context"
 	"crypto/rand"
 	"encoding/base64"
+	"errors"
 	"fmt"
 	"maps"
+	"net/url"
 	"slices"
 	"strings"
+	"sync"
+	"time"
 
 	age "filippo.io/age"
 	esc "github.com/pulumi/esc-sdk/sdk/go"
+	esc_workspace "github.com/pulumi/esc/cmd/esc/cli/workspace"
 	"github.com/pulumi/pulumi/sdk/v3/go/pulumix"
 )
 
+const (
+	defaultSharedEnv = "aplcli/shared"
+	escUpdateRetries = 5
+)
+
+var (
+	// escMu serializes read-merge-write updates within a process.
+	escMu sync.Mutex
+	// sharedEnvItems are kept in the org-level shared environment, when the
+	// platform uses one, instead of in each platform environment.
+	sharedEnvItems = []string{"linode"}
+	// errEscUpdateCancelled is returned when the user declines the changes of
+	// a confirmed update.
+	errEscUpdateCancelled = errors.New("esc environment update cancelled")
+)
+
 type EscEnv struct {
+	Confirm    bool
 	EnvName    string
 	Config     map[string]any
+	Imports    []string
 	Items      map[int]EscEnvItem
 	OrgName    string
 	ProjName   string
@@ -75,6 +99,12 @@ func (e *EscEnv) Init() {
 	v := make(map[string]any)
 	values := e.BuildValues(v)
 
+	if !e.confirmUpdate(map[string]string{}, values) {
+		logger.Warn("esc environment left empty: " + errEscUpdateCancelled.Error())
+
+		return
+	}
+
 	if err := e.Write(values); err != nil {
 		logger.Error("write initial esc environment: " + err.Error())
 	}
@@ -86,78 +116,154 @@ func (e *EscEnv) AddConfig(c map[string]any) *EscEnv {
 	return e
 }
 
-func (e *EscEnv) GetConfig(key string) (string, int) {
+// Update merges the config, imports and items into the environment. It
+// returns errEscUpdateCancelled when Confirm is set and the user declines.
+func (e *EscEnv) Update() error {
+	// updates issued from concurrent ApplyT callbacks in one process are
+	// serialized, other writers are detected by etag and re-merged
+	escMu.Lock()
+	defer escMu.Unlock()
+
 	authCtx, escClient, err := esc.DefaultLogin()
 	if err != nil {
-		logger.Error("auth to esc environment for update: " + err.Error())
+		return errors.New("auth to esc environment for update: " + err.Error())
 	}
 
-	_, config, err := escClient.OpenAndReadEnvironment(authCtx, e.OrgName, e.ProjName, e.EnvName)
-	if err != nil {
-		logger.Error("open and read esc environment: " + err.Error())
+	config := maps.Clone(e.Config)
+	imports := slices.Clone(e.Imports)
+
+	for attempt := 1; attempt <= escUpdateRetries; attempt++ {
+		env, etag, err := getEnvironment(authCtx, escClient, e.OrgName, e.ProjName, e.EnvName)
+		if err != nil {
+			return errors.New("get existing esc environment: " + err.Error())
+		}
+
+		// snapshot the existing definition before the merge mutates it
+		before := make(map[string]string)
+		if v, err := env.GetValues().ToMap(); err == nil {
+			flattenEnv("", v, before)
+		}
+
+		// merge new and existing pulumiConfig maps
+		c := env.GetValues().PulumiConfig
+		if c == nil {
+			c = make(map[string]any)
+		}
+
+		maps.Copy(c, config)
+		e.Config = c
+
+		// keep existing imports, new ones are appended
+		e.Imports = slices.Clone(env.Imports)
+		for _, i := range imports {
+			if !slices.Contains(e.Imports, i) {
+				e.Imports = append(e.Imports, i)
+			}
+		}
+
+		// build new values map from new and existing
+		v, err := env.GetValues().ToMap()
+		if err != nil {
+			logger.Error("get existing esc pulumi config: " + err.Error())
+		}
+
+		values := v
+
+		switch {
+		case len(e.Objects) > 0:
+			for _, i := range e.Objects {
+				values[i.Name] = i.Value
+			}
+		case len(e.Items) > 0:
+			values = e.BuildValues(values)
+		}
+
+		if !e.confirmUpdate(before, values) {
+			return errEscUpdateCancelled
+		}
+
+		err = e.writeIfMatch(authCtx, values, etag)
+		if err == nil {
+			return nil
+		}
+
+		if !isEscConflict(err) {
+			return errors.New("write update to esc environment: " + err.Error())
+		}
+
+		msg := fmt.Sprintf("esc environment changed during update: retrying (%d/%d)", attempt, escUpdateRetries)
+		logger.Warn(msg)
+
+		time.Sleep(time.Duration(attempt) * time.Second)
 	}
 
-	value, ok := config[key]
-	if !ok {
-		msg := fmt.Sprintf("\"%s\" not found", key)
-		logger.Error("get value from esc environment: " + msg)
+	return errors.New("write update to esc environment: too many conflicting writes")
+}
+
+// confirmUpdate shows the changes a pending write makes to the environment.
+// When Confirm is set the write only goes ahead once the user accepts them.
+func (e *EscEnv) confirmUpdate(before map[string]string, values map[string]any) bool {
+	after := make(map[string]string)
+
+	pending := esc.EnvironmentDefinitionValues{
+		PulumiConfig:         e.Config,
+		AdditionalProperties: values,
+	}
+	if v, err := pending.ToMap(); err == nil {
+		flattenEnv("", v, after)
 	}
 
-	switch v := value.(type) {
-	case string:
-		return v, 0
-	case int:
-		return "", v
-	default:
-		return "", 0
+	changes := diffEnvLeaves(before, after)
+
+	logger.Info("header:" + fmt.Sprintf("esc %s/%s/%s changes", e.OrgName, e.ProjName, e.EnvName))
+	printEnvChanges(changes)
+
+	if !e.Confirm || len(changes) == 0 {
+		return true
 	}
+
+	return InputPrompt("warn", "YES", "write changes to esc environment? (type YES to confirm)")
 }
 
-func (e *EscEnv) Update() {
+func (e *EscEnv) Write(values map[string]any) error {
 	authCtx, escClient, err := esc.DefaultLogin()
 	if err != nil {
-		logger.Error("auth to esc environment for update: " + err.Error())
+		return err
 	}
 
-	env, _, err := escClient.GetEnvironment(authCtx, e.OrgName, e.ProjName, e.EnvName)
-	if err != nil {
-		logger.Error("get existing esc environment: " + err.Error())
+	payload := &esc.EnvironmentDefinition{
+		Imports: e.Imports,
+		Values: &esc.EnvironmentDefinitionValues{
+			PulumiConfig:         e.Config,
+			AdditionalProperties: values,
+		},
 	}
 
-	// merge new and existing pulumiConfig maps
-	c := env.Values.PulumiConfig
-	maps.Copy(c, e.Config)
-	e.Config = c
-
-	// build new values map from new and existing
-	v, err := env.GetValues().ToMap()
+	_, err = escClient.UpdateEnvironment(authCtx, e.OrgName, e.ProjName, e.EnvName, payload)
 	if err != nil {
-		logger.Error("get existing esc pulumi config: " + err.Error())
+		return err
 	}
 
-	values := v
-
-	switch {
-	case len(e.Objects) > 0:
-		for _, i := range e.Objects {
-			values[i.Name] = i.Value
-		}
-	case len(e.Items) > 0:
-		values = e.BuildValues(values)
-	}
+	return nil
+}
 
-	if err := e.Write(values); err != nil {
-		logger.Error("write update to esc environment: " + err.Error())
+// writeIfMatch writes the environment only if it is unchanged since the etag
+// was read. ESC rejects a stale etag with a conflict.
+func (e *EscEnv) writeIfMatch(authCtx context.Context, values map[string]any, etag string) error {
+	if etag == "" {
+		return e.Write(values)
 	}
-}
 
-func (e *EscEnv) Write(values map[string]any) error {
-	authCtx, escClient, err := esc.DefaultLogin()
+	cfg, err := escConfiguration()
 	if err != nil {
 		return err
 	}
 
+	cfg.AddDefaultHeader("If-Match", etag)
+	escClient := esc.NewClient(cfg)
+
 	payload := &esc.EnvironmentDefinition{
+		Imports: e.Imports,
 		Values: &esc.EnvironmentDefinitionValues{
 			PulumiConfig:         e.Config,
 			AdditionalProperties: values,
@@ -165,11 +271,43 @@ func (e *EscEnv) Write(values map[string]any) error {
 	}
 
 	_, err = escClient.UpdateEnvironment(authCtx, e.OrgName, e.ProjName, e.EnvName, payload)
+
+	return err
+}
+
+// escConfiguration returns the client configuration for the backend the
+// pulumi or esc CLI is logged in to, resolved the same way esc.DefaultLogin
+// does, so self-hosted backends get the write too.
+func escConfiguration() (*esc.Configuration, error) {
+	ws := esc_workspace.New(esc_workspace.DefaultFS(), esc_workspace.DefaultPulumiWorkspace())
+
+	account, _, err := ws.GetCurrentAccount(false)
 	if err != nil {
-		return err
+		return nil, errors.New("get current pulumi account: " + err.Error())
 	}
 
-	return nil
+	backendURL, err := url.Parse(ws.GetCurrentCloudURL(account))
+	if err != nil {
+		return nil, errors.New("parse pulumi backend url: " + err.Error())
+	}
+
+	return esc.NewCustomBackendConfiguration(*backendURL)
+}
+
+// getEnvironment returns the environment definition along with the etag
+// needed for a conditional write.
+func getEnvironment(ctx context.Context, c *esc.EscClient, org, proj, env string) (*esc.EnvironmentDefinition, string, error) {
+	def, res, err := c.EscAPI.GetEnvironment(ctx, org, proj, env).Execute()
+	if err != nil {
+		return nil, "", err
+	}
+
+	return def, res.Header.Get("ETag"), nil
+}
+
+func isEscConflict(err error) bool {
+	return strings.Contains(err.Error(), "409 Conflict") ||
+		strings.Contains(err.Error(), "412 Precondition Failed")
 }
 
 func (e *EscEnv) Remove() {
@@ -186,6 +324,150 @@ func (e *EscEnv) Remove() {
 	logger.Info("purged esc environment")
 }
 
+// splitEnvRef splits a <project>/<environment> reference as used in imports.
+func splitEnvRef(ref string) (string, string, bool) {
+	proj, env, ok := strings.Cut(ref, "/")
+
+	return proj, env, ok && proj != "" && env != "" && !strings.Contains(env, "/")
+}
+
+// splitSharedItems separates the items that belong in the shared environment
+// from those kept in the platform environment.
+func splitSharedItems(items map[int]EscEnvItem) (map[int]EscEnvItem, map[int]EscEnvItem) {
+	shared := make(map[int]EscEnvItem)
+	own := make(map[int]EscEnvItem)
+
+	for k, v := range items {
+		if slices.Contains(sharedEnvItems, v.Name) {
+			shared[k] = v
+		} else {
+			own[k] = v
+		}
+	}
+
+	return shared, own
+}
+
+// initSharedEnv creates the org-level shared environment with the given items.
+// Items an existing shared environment already provides are left as is, so
+// adding a platform never overwrites the credentials other platforms import,
+// and only the missing ones are merged in. It fails unless the shared
+// environment ends up providing every item.
+func initSharedEnv(org, ref string, items map[int]EscEnvItem) error {
+	proj, name, ok := splitEnvRef(ref)
+	if !ok {
+		return fmt.Errorf("shared environment %q: wants <project>/<environment>", ref)
+	}
+
+	env := NewEnvObject(org, proj, name)
+
+	if !EscExists(org, proj, name) {
+		env.Items = items
+		env.Init()
+
+		logger.Info("created shared esc environment " + ref)
+
+		return sharedEnvProvides(org, ref, items)
+	}
+
+	missing := sharedEnvMissing(org, ref, items)
+	if len(missing) == 0 {
+		logger.Info("using shared esc environment " + ref)
+
+		return nil
+	}
+
+	env.Items = missing
+	if err := env.Update(); err != nil {
+		return err
+	}
+
+	logger.Info("added missing values to shared esc environment " + ref)
+
+	return sharedEnvProvides(org, ref, items)
+}
+
+// sharedEnvMissing returns the items the shared environment has no value for.
+func sharedEnvMissing(org, ref string, items map[int]EscEnvItem) map[int]EscEnvItem {
+	proj, name, _ := splitEnvRef(ref)
+	missing := maps.Clone(items)
+
+	authCtx, escClient, err := esc.DefaultLogin()
+	if err != nil {
+		logger.Error("auth to read shared esc environment: " + err.Error())
+
+		return missing
+	}
+
+	def, _, err := escClient.GetEnvironment(authCtx, org, proj, name)
+	if err != nil {
+		return missing
+	}
+
+	maps.DeleteFunc(missing, func(_ int, i EscEnvItem) bool {
+		_, ok := def.GetValues().AdditionalProperties[i.Name]
+
+		return ok
+	})
+
+	return missing
+}
+
+func sharedEnvProvides(org, ref string, items map[int]EscEnvItem) error {
+	var names []string
+	for _, i := range sharedEnvMissing(org, ref, items) {
+		names = append(names, i.Name)
+	}
+
+	if len(names) > 0 {
+		slices.Sort(names)
+
+		return fmt.Errorf("shared esc environment %s does not provide %s", ref, strings.Join(names, ", "))
+	}
+
+	return nil
+}
+
+// sharedEnvFor returns the imported environment that provides item to the
+// platform environment. It is not found when the platform environment holds
+// its own value, which takes precedence over imports.
+func sharedEnvFor(org, item string) (EscEnv, bool) {
+	authCtx, escClient, err := esc.DefaultLogin()
+	if err != nil {
+		logger.Error("auth to find shared esc environment: " + err.Error())
+
+		return EscEnv{}, false
+	}
+
+	def, _, err := escClient.GetEnvironment(authCtx, org, platform.Name, platform.Stack)
+	if err != nil {
+		return EscEnv{}, false
+	}
+
+	if _, ok := def.GetValues().AdditionalProperties[item]; ok {
+		return EscEnv{}, false
+	}
+
+	// later imports override earlier ones
+	for _, ref := range slices.Backward(def.Imports) {
+		proj, name, ok := splitEnvRef(ref)
+		if !ok {
+			continue
+		}
+
+		imp, _, err := escClient.GetEnvironment(authCtx, org, proj, name)
+		if err != nil {
+			continue
+		}
+
+		if _, ok := imp.GetValues().AdditionalProperties[item]; ok {
+			return NewEnvObject(org, proj, name), true
+		}
+	}
+
+	return EscEnv{}, false
+}
+
 func (e *EscEnv) BuildValues(val map[string]any) map[string]any {
 	isValid := func(i any) bool {
 		switch i.(type) {
@@ -236,7 +518,9 @@ func (px *PulumixEscEnvItem) Write(env EscEnv) {
 			Value: m,
 		}
 		env.Items = BuildEscItems(item)
-		env.Update()
+		if err := env.Update(); err != nil {
+			logger.Error(err.Error())
+		}
 
 		return nil
 	})
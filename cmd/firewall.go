@@ -0,0 +1,299 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/linode/linodego"
+	"github.com/pulumi/pulumi/sdk/v3/go/auto"
+	"github.com/spf13/cobra"
+)
+
+// FirewallConfig is the Cloud Firewall of the LKE nodes, set in the platform
+// config under firewall. The rules LKE needs between nodes and NodePorts from
+// NodeBalancers are always allowed.
+type FirewallConfig struct {
+	Enabled          bool     `yaml:"enabled,omitempty"`
+	AllowCidrs       []string `yaml:"allowcidrs,omitempty"`
+	NodeBalancerOnly bool     `yaml:"nodebalanceronly,omitempty"`
+}
+
+// allowRulePrefix labels the inbound rules built from the allowed CIDRs, the
+// same way the infra project does.
+const allowRulePrefix = "allow-cidrs-"
+
+var firewallCmd = &cobra.Command{
+	Use:   "firewall",
+	Short: "Show and update the Cloud Firewall of platform nodes",
+}
+
+var firewallShowCmd = &cobra.Command{
+	Use:   "show",
+	Short: "Show the live firewall rules and attached devices",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx, cancel := context.WithTimeout(context.Background(), 1*time.Minute)
+		defer cancel()
+
+		client := NewLinodeClient()
+
+		fw, err := platformFirewall(ctx, &client)
+		if err != nil {
+			logger.Error("firewall show: " + err.Error())
+
+			return
+		}
+
+		rules, err := client.GetFirewallRules(ctx, fw.ID)
+		if err != nil {
+			logger.Error("get firewall rules: " + err.Error())
+
+			return
+		}
+
+		devices, err := client.ListFirewallDevices(ctx, fw.ID, &linodego.ListOptions{})
+		if err != nil {
+			logger.Error("list firewall devices: " + err.Error())
+
+			return
+		}
+
+		msg := fmt.Sprintf("header:firewall %s (%d): inbound %s, outbound %s, %d devices",
+			fw.Label, fw.ID, rules.InboundPolicy, rules.OutboundPolicy, len(devices))
+		logger.Info(msg)
+
+		for _, i := range rules.Inbound {
+			ports := i.Ports
+			if ports == "" {
+				ports = "all"
+			}
+
+			line := fmt.Sprintf("%-20s %-7s %-8s %-12s %s",
+				i.Label, i.Action, i.Protocol, ports, strings.Join(ruleAddresses(i), ", "))
+			logger.Info("line:" + line)
+		}
+
+		fmt.Println() //nolint:forbidigo
+	},
+}
+
+var firewallAllowCmd = &cobra.Command{
+	Use:   "allow <cidr>...",
+	Short: "Allow inbound traffic to platform nodes from CIDRs or IPs",
+	Args:  cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := updateFirewallCidrs(context.Background(), args, nil); err != nil {
+			logger.Error("firewall allow: " + err.Error())
+		}
+	},
+}
+
+var firewallDenyCmd = &cobra.Command{
+	Use:   "deny <cidr>...",
+	Short: "Remove CIDRs or IPs from the allowed inbound sources",
+	Args:  cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := updateFirewallCidrs(context.Background(), nil, args); err != nil {
+			logger.Error("firewall deny: " + err.Error())
+		}
+	},
+}
+
+func init() {
+	// required flags
+	firewallCmd.PersistentFlags().StringVarP(&platform.Name, "name", "n", "", "APL instance name (required)")
+	firewallCmd.MarkPersistentFlagRequired("name") //nolint:errcheck
+
+	firewallCmd.AddCommand(firewallAllowCmd, firewallDenyCmd, firewallShowCmd)
+}
+
+// platformFirewall finds the firewall the infra stack created for the
+// platform.
+func platformFirewall(ctx context.Context, client *linodego.Client) (*linodego.Firewall, error) {
+	label := platform.Name + "-fw"
+	filter := fmt.Sprintf(`{"label": %q}`, label)
+
+	firewalls, err := client.ListFirewalls(ctx, linodego.NewListOptions(0, filter))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(firewalls) == 0 {
+		return nil, errors.New("firewall " + label + " not found: set firewall.enabled in the platform config and deploy")
+	}
+
+	return &firewalls[0], nil
+}
+
+// updateFirewallCidrs adds and removes allowed CIDRs on the live firewall and
+// pins the result in infra stack config as firewallAllowCidrs, which the
+// infra project prefers over the platform config on the next deploy.
+func updateFirewallCidrs(ctx context.Context, add, remove []string) error {
+	s := initLocalStack(ctx, infraStack(ctx))
+	cur := stackFirewallCidrs(ctx, s)
+	want := slices.Clone(cur)
+
+	for _, i := range add {
+		cidr, err := parseCidr(i)
+		if err != nil {
+			return err
+		}
+
+		if !slices.Contains(want, cidr) {
+			want = append(want, cidr)
+		}
+	}
+
+	for _, i := range remove {
+		cidr, err := parseCidr(i)
+		if err != nil {
+			return err
+		}
+
+		if !slices.Contains(want, cidr) {
+			logger.Warn(cidr + " is not an allowed cidr")
+		}
+
+		want = slices.DeleteFunc(want, func(c string) bool { return c == cidr })
+	}
+
+	if slices.Equal(cur, want) {
+		logger.Info("firewall is already up to date")
+
+		return nil
+	}
+
+	client := NewLinodeClient()
+
+	fw, err := platformFirewall(ctx, &client)
+	if err != nil {
+		return err
+	}
+
+	rules, err := client.GetFirewallRules(ctx, fw.ID)
+	if err != nil {
+		return err
+	}
+
+	rules.Inbound = slices.DeleteFunc(rules.Inbound, func(r linodego.FirewallRule) bool {
+		return strings.HasPrefix(r.Label, allowRulePrefix)
+	})
+	rules.Inbound = append(rules.Inbound, allowRules(want)...)
+
+	if _, err := client.UpdateFirewallRules(ctx, fw.ID, *rules); err != nil {
+		return errors.New("update firewall rules: " + err.Error())
+	}
+
+	data, err := json.Marshal(want)
+	if err != nil {
+		return err
+	}
+
+	if err := s.SetConfig(ctx, "firewallAllowCidrs", auto.ConfigValue{Value: string(data)}); err != nil {
+		return errors.New("set firewallAllowCidrs in infra stack config: " + err.Error())
+	}
+
+	msg := fmt.Sprintf("firewall %s allows: %s", fw.Label, strings.Join(want, ", "))
+	if len(want) == 0 {
+		msg = fmt.Sprintf("firewall %s allows no extra cidrs", fw.Label)
+	}
+
+	logger.Info(msg)
+
+	return nil
+}
+
+// stackFirewallCidrs returns the allowed CIDRs pinned in stack config, falling
+// back to the platform config.
+func stackFirewallCidrs(ctx context.Context, s auto.Stack) []string {
+	v, err := s.GetConfig(ctx, "firewallAllowCidrs")
+	if err != nil || v.Value == "" {
+		return slices.Clone(platform.Firewall.AllowCidrs)
+	}
+
+	var cidrs []string
+	if err := json.Unmarshal([]byte(v.Value), &cidrs); err != nil {
+		logger.Warn("json unmarshal firewallAllowCidrs: " + err.Error())
+
+		return slices.Clone(platform.Firewall.AllowCidrs)
+	}
+
+	return cidrs
+}
+
+// parseCidr normalizes a CIDR, or an IP address to a single host CIDR.
+func parseCidr(s string) (string, error) {
+	if _, ipnet, err := net.ParseCIDR(s); err == nil {
+		return ipnet.String(), nil
+	}
+
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return "", fmt.Errorf("%q is not a cidr or ip address", s)
+	}
+
+	if ip.To4() != nil {
+		return ip.String() + "/32", nil
+	}
+
+	return ip.String() + "/128", nil
+}
+
+// allowRules builds the TCP, UDP and ICMP rules for the allowed CIDRs.
+func allowRules(cidrs []string) []linodego.FirewallRule {
+	if len(cidrs) == 0 {
+		return nil
+	}
+
+	var ipv4, ipv6 []string
+
+	for _, i := range cidrs {
+		if strings.Contains(i, ":") {
+			ipv6 = append(ipv6, i)
+		} else {
+			ipv4 = append(ipv4, i)
+		}
+	}
+
+	rules := make([]linodego.FirewallRule, 0, 3)
+
+	for _, proto := range []linodego.NetworkProtocol{linodego.TCP, linodego.UDP, linodego.ICMP} {
+		rule := linodego.FirewallRule{
+			Action:   "ACCEPT",
+			Label:    allowRulePrefix + strings.ToLower(string(proto)),
+			Protocol: proto,
+		}
+
+		if len(ipv4) > 0 {
+			rule.Addresses.IPv4 = &ipv4
+		}
+
+		if len(ipv6) > 0 {
+			rule.Addresses.IPv6 = &ipv6
+		}
+
+		rules = append(rules, rule)
+	}
+
+	return rules
+}
+
+func ruleAddresses(r linodego.FirewallRule) []string {
+	var addrs []string
+
+	if r.Addresses.IPv4 != nil {
+		addrs = append(addrs, *r.Addresses.IPv4...)
+	}
+
+	if r.Addresses.IPv6 != nil {
+		addrs = append(addrs, *r.Addresses.IPv6...)
+	}
+
+	return addrs
+}
@@ -0,0 +1,570 @@
+package cmd
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/linode/linodego"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"gopkg.in/yaml.v2"
+)
+
+// gcResource is a Linode resource created by aplcli or LKE for a platform that
+// nothing refers to anymore. Resources that cannot be linked to a platform are
+// only listed, never deleted.
+type gcResource struct {
+	Kind     string
+	ID       int
+	Label    string
+	ListOnly bool
+	Region   string
+	Platform string
+	Reason   string
+}
+
+// gcRefs is what the Pulumi state of aplcli stacks refers to: every id and
+// label found in their resources, and the platforms the stacks belong to.
+type gcRefs struct {
+	platforms map[string]bool
+	values    map[string]bool
+}
+
+// gcScan holds the live LKE clusters and the platforms from config and state
+// that resources are matched against.
+type gcScan struct {
+	clusters  map[int]bool
+	platforms []Platform
+	refs      gcRefs
+}
+
+// objBucketNames matches the objLabels of the infra project, buckets are
+// labeled <objPrefix>-<name>.
+var objBucketNames = []string{"cnpg", "gitea", "harbor", "loki", "tempo", "thanos", "velero"}
+
+var gcApply bool
+
+var gcCmd = &cobra.Command{
+	Use:   "gc",
+	Short: "Find and delete Linode resources left behind by platforms",
+	Long: `Find volumes, NodeBalancers, firewalls, object storage keys and buckets
+that were created for a platform but are no longer used by a live LKE cluster
+or referred to by the Pulumi state of an aplcli stack. Resources are only
+listed unless --apply is set, and resources that cannot be linked to a
+platform are never deleted.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+		defer cancel()
+
+		refs, err := stateRefs(ctx, viper.GetString("pulumiOrg"), configPlatforms())
+		if err != nil {
+			logger.Error("read pulumi state: " + err.Error())
+			logger.Warn("gc stopped: without pulumi state every resource looks orphaned")
+
+			return
+		}
+
+		client := NewLinodeClient()
+
+		orphans, err := findOrphans(ctx, &client, refs)
+		if err != nil {
+			logger.Error("scan linode account: " + err.Error())
+
+			return
+		}
+
+		if len(orphans) == 0 {
+			logger.Info("no orphaned resources found")
+
+			return
+		}
+
+		logger.Info("header:orphaned resources")
+
+		for _, i := range orphans {
+			line := fmt.Sprintf("%-14s %-10d %-40s %-16s %s", i.Kind, i.ID, i.Label, i.Platform, i.Reason)
+			logger.Info("line:" + line)
+		}
+
+		fmt.Println() //nolint:forbidigo
+
+		deletable := slices.DeleteFunc(slices.Clone(orphans), func(r gcResource) bool { return r.ListOnly })
+		if n := len(orphans) - len(deletable); n > 0 {
+			msg := fmt.Sprintf("%d resources are not linked to a platform: delete them manually if they are unused", n)
+			logger.Warn(msg)
+		}
+
+		if len(deletable) == 0 {
+			return
+		}
+
+		if !gcApply {
+			msg := fmt.Sprintf("%d orphaned resources found: run again with --apply to delete them", len(deletable))
+			logger.Info(msg)
+
+			return
+		}
+
+		prompt := fmt.Sprintf("delete %d orphaned resources? (type YES to confirm)", len(deletable))
+		if ok := InputPrompt("warn", "YES", prompt); !ok {
+			logger.Warn("gc cancelled")
+
+			return
+		}
+
+		deleted := 0
+
+		for _, i := range deletable {
+			if err := deleteOrphan(ctx, &client, i); err != nil {
+				logger.Error(fmt.Sprintf("delete %s %s: %s", i.Kind, i.Label, err.Error()))
+
+				continue
+			}
+
+			deleted++
+		}
+
+		msg := fmt.Sprintf("%d/%d orphaned resources deleted", deleted, len(deletable))
+		logger.Info(msg)
+	},
+}
+
+func init() {
+	// optional flags
+	gcCmd.Flags().BoolVarP(&gcApply, "apply", "", false, "Delete the orphaned resources found")
+}
+
+// stateRefs exports the state of every stack with a platform tag, and of the
+// <name> and <name>-infra stacks of every configured platform, which carry no
+// tag when they were last deployed before stacks were tagged. An export that
+// fails, or a configured platform without any readable stack, is returned as
+// an error, since gc cannot tell what that platform still uses.
+func stateRefs(ctx context.Context, org string, platforms []Platform) (gcRefs, error) {
+	refs := gcRefs{
+		platforms: make(map[string]bool),
+		values:    make(map[string]bool),
+	}
+	exported := make(map[string]bool)
+
+	export := func(fqsn string) error {
+		if exported[fqsn] {
+			return nil
+		}
+
+		var state any
+		if err := pulumiGet(ctx, "/stacks/"+fqsn+"/export", &state); err != nil {
+			return fmt.Errorf("export stack %s: %w", fqsn, err)
+		}
+
+		collectStateValues(state, refs.values)
+		exported[fqsn] = true
+
+		return nil
+	}
+
+	stacks, err := listStacks(ctx, org, "platform", "")
+	if err != nil {
+		return refs, err
+	}
+
+	for _, i := range stacks {
+		if err := export(fmt.Sprintf("%s/%s/%s", i.OrgName, i.ProjectName, i.StackName)); err != nil {
+			return refs, err
+		}
+
+		if name := i.Tags["platform"]; name != "" {
+			refs.platforms[name] = true
+		}
+	}
+
+	for _, p := range platforms {
+		stack := cmp.Or(p.Stack, "dev")
+		found := false
+
+		for _, proj := range []string{p.Name, p.Name + "-infra"} {
+			// a project without this stack was never deployed
+			if _, err := getStack(ctx, org, proj, stack); err != nil {
+				continue
+			}
+
+			if err := export(fmt.Sprintf("%s/%s/%s", org, proj, stack)); err != nil {
+				return refs, err
+			}
+
+			found = true
+		}
+
+		if !found {
+			return refs, fmt.Errorf("platform %s: no readable stack %s in projects %s or %s-infra",
+				p.Name, stack, p.Name, p.Name)
+		}
+
+		refs.platforms[p.Name] = true
+	}
+
+	return refs, nil
+}
+
+// collectStateValues adds every string and number in a stack export to values.
+// Resource ids and labels are matched against it rather than parsing each
+// resource type.
+func collectStateValues(v any, values map[string]bool) {
+	switch t := v.(type) {
+	case map[string]any:
+		for _, i := range t {
+			collectStateValues(i, values)
+		}
+	case []any:
+		for _, i := range t {
+			collectStateValues(i, values)
+		}
+	case string:
+		values[t] = true
+	case float64:
+		values[strconv.FormatFloat(t, 'f', -1, 64)] = true
+	}
+}
+
+// configPlatforms returns every platform in the config file.
+func configPlatforms() []Platform {
+	platforms := make([]Platform, 0, len(cfgArray))
+
+	for _, i := range cfgArray {
+		var p Platform
+
+		data, err := yaml.Marshal(i)
+		if err != nil {
+			continue
+		}
+
+		if err := yaml.Unmarshal(data, &p); err != nil {
+			logger.Warn(fmt.Sprintf("skipping platform config %v: %s", i["name"], err.Error()))
+
+			continue
+		}
+
+		if p.ObjPrefix == "" {
+			p.ObjPrefix = "apl"
+		}
+
+		platforms = append(platforms, p)
+	}
+
+	return platforms
+}
+
+func findOrphans(ctx context.Context, client *linodego.Client, refs gcRefs) ([]gcResource, error) {
+	scan := gcScan{
+		clusters:  make(map[int]bool),
+		platforms: configPlatforms(),
+		refs:      refs,
+	}
+
+	clusters, err := client.ListLKEClusters(ctx, &linodego.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("list lke clusters: %w", err)
+	}
+
+	for _, i := range clusters {
+		scan.clusters[i.ID] = true
+	}
+
+	steps := []func(context.Context, *linodego.Client) ([]gcResource, error){
+		scan.volumes,
+		scan.nodeBalancers,
+		scan.firewalls,
+		scan.buckets,
+		scan.objKeys,
+	}
+
+	var orphans []gcResource
+
+	for _, step := range steps {
+		found, err := step(ctx, client)
+		if err != nil {
+			return nil, err
+		}
+
+		orphans = append(orphans, found...)
+	}
+
+	return orphans, nil
+}
+
+// deadCluster reports if a label belongs to an LKE cluster that no longer
+// exists.
+func (g gcScan) deadCluster(label string) (bool, string) {
+	id, ok := lkeLabelID(label)
+	if !ok {
+		return false, ""
+	}
+
+	return !g.clusters[id], fmt.Sprintf("lke cluster %d not found", id)
+}
+
+// tagged returns the platform whose tags are all set on a resource.
+func (g gcScan) tagged(tags []string) (Platform, bool) {
+	for _, p := range g.platforms {
+		if len(p.Tags) == 0 {
+			continue
+		}
+
+		match := true
+
+		for _, t := range p.Tags {
+			if !slices.Contains(tags, t) {
+				match = false
+
+				break
+			}
+		}
+
+		if match {
+			return p, true
+		}
+	}
+
+	return Platform{}, false
+}
+
+// volumes finds volumes of deleted clusters, and detached volumes that
+// cleanup tagged for a platform but could not delete.
+func (g gcScan) volumes(ctx context.Context, client *linodego.Client) ([]gcResource, error) {
+	volumes, err := client.ListVolumes(ctx, &linodego.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("list volumes: %w", err)
+	}
+
+	var orphans []gcResource
+
+	for _, i := range volumes {
+		r := gcResource{Kind: "volume", ID: i.ID, Label: i.Label, Region: i.Region}
+
+		if dead, reason := g.deadCluster(i.LinodeLabel); dead {
+			r.Reason = reason
+			orphans = append(orphans, r)
+
+			continue
+		}
+
+		if i.LinodeID != nil {
+			continue
+		}
+
+		if name, ok := g.volumePlatform(i.Tags); ok {
+			r.Platform = name
+			r.Reason = "detached, tagged for cleanup"
+			orphans = append(orphans, r)
+		}
+	}
+
+	return orphans, nil
+}
+
+// volumePlatform returns the platform, from config or state, whose cleanup
+// tag, aplcli-<name> or <name>-volume of earlier releases, is set on a volume.
+func (g gcScan) volumePlatform(tags []string) (string, bool) {
+	names := slices.Collect(maps.Keys(g.refs.platforms))
+	for _, p := range g.platforms {
+		names = append(names, p.Name)
+	}
+
+	for _, name := range names {
+		if name != "" && (slices.Contains(tags, "aplcli-"+name) || slices.Contains(tags, name+"-volume")) {
+			return name, true
+		}
+	}
+
+	return "", false
+}
+
+// nodeBalancers finds NodeBalancers of deleted clusters, and NodeBalancers
+// with a platform nbTag that no stack refers to.
+func (g gcScan) nodeBalancers(ctx context.Context, client *linodego.Client) ([]gcResource, error) {
+	nodebalancers, err := client.ListNodeBalancers(ctx, &linodego.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("list nodebalancers: %w", err)
+	}
+
+	var orphans []gcResource
+
+	for _, i := range nodebalancers {
+		label := ""
+		if i.Label != nil {
+			label = *i.Label
+		}
+
+		r := gcResource{Kind: "nodebalancer", ID: i.ID, Label: label, Region: i.Region}
+
+		if dead, reason := g.deadCluster(label); dead {
+			r.Reason = reason
+			orphans = append(orphans, r)
+
+			continue
+		}
+
+		if g.refs.values[strconv.Itoa(i.ID)] {
+			continue
+		}
+
+		for _, p := range g.platforms {
+			if p.NbTag != "" && slices.Contains(i.Tags, p.NbTag) {
+				r.Platform = p.Name
+				r.Reason = "not in pulumi state"
+				orphans = append(orphans, r)
+
+				break
+			}
+		}
+	}
+
+	return orphans, nil
+}
+
+// firewalls finds firewalls of deleted clusters, and firewalls with platform
+// tags that protect nothing and that no stack refers to.
+func (g gcScan) firewalls(ctx context.Context, client *linodego.Client) ([]gcResource, error) {
+	firewalls, err := client.ListFirewalls(ctx, &linodego.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("list firewalls: %w", err)
+	}
+
+	var orphans []gcResource
+
+	for _, i := range firewalls {
+		r := gcResource{Kind: "firewall", ID: i.ID, Label: i.Label}
+
+		if dead, reason := g.deadCluster(i.Label); dead {
+			r.Reason = reason
+			orphans = append(orphans, r)
+
+			continue
+		}
+
+		p, ok := g.tagged(i.Tags)
+		if !ok || g.refs.values[strconv.Itoa(i.ID)] {
+			continue
+		}
+
+		devices, err := client.ListFirewallDevices(ctx, i.ID, &linodego.ListOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("list firewall %d devices: %w", i.ID, err)
+		}
+
+		if len(devices) == 0 {
+			r.Platform = p.Name
+			r.Reason = "no devices, not in pulumi state"
+			orphans = append(orphans, r)
+		}
+	}
+
+	return orphans, nil
+}
+
+// buckets finds platform buckets, <objPrefix>-<name>, that no stack refers
+// to. Buckets must be empty before they can be deleted.
+func (g gcScan) buckets(ctx context.Context, client *linodego.Client) ([]gcResource, error) {
+	buckets, err := client.ListObjectStorageBuckets(ctx, &linodego.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("list buckets: %w", err)
+	}
+
+	var orphans []gcResource
+
+	for _, i := range buckets {
+		if g.refs.values[i.Label] {
+			continue
+		}
+
+		for _, p := range g.platforms {
+			name, ok := strings.CutPrefix(i.Label, p.ObjPrefix+"-")
+			if !ok || !slices.Contains(objBucketNames, name) {
+				continue
+			}
+
+			region := i.Region
+			if region == "" {
+				region = i.Cluster
+			}
+
+			orphans = append(orphans, gcResource{
+				Kind:     "bucket",
+				Label:    i.Label,
+				Region:   region,
+				Platform: p.Name,
+				Reason:   "not in pulumi state",
+			})
+
+			break
+		}
+	}
+
+	return orphans, nil
+}
+
+// objKeys finds the keys the infra stack creates, labeled <name>-obj-key, that
+// no stack refers to. Keys with the pulumi-obj-key label of earlier releases
+// carry no platform name, so they are only listed.
+func (g gcScan) objKeys(ctx context.Context, client *linodego.Client) ([]gcResource, error) {
+	keys, err := client.ListObjectStorageKeys(ctx, &linodego.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("list object storage keys: %w", err)
+	}
+
+	var orphans []gcResource
+
+	for _, i := range keys {
+		if g.refs.values[strconv.Itoa(i.ID)] || g.refs.values[i.AccessKey] {
+			continue
+		}
+
+		r := gcResource{Kind: "obj key", ID: i.ID, Label: i.Label, Reason: "not in pulumi state"}
+
+		switch name, ok := strings.CutSuffix(i.Label, "-obj-key"); {
+		case i.Label == "pulumi-obj-key":
+			r.ListOnly = true
+			r.Reason = "not in pulumi state, no platform in label: not deleted"
+		case ok && g.knownPlatform(name):
+			r.Platform = name
+		default:
+			continue
+		}
+
+		orphans = append(orphans, r)
+	}
+
+	return orphans, nil
+}
+
+// knownPlatform reports whether a platform is configured or has a stack.
+func (g gcScan) knownPlatform(name string) bool {
+	if g.refs.platforms[name] {
+		return true
+	}
+
+	return slices.ContainsFunc(g.platforms, func(p Platform) bool { return p.Name == name })
+}
+
+func deleteOrphan(ctx context.Context, client *linodego.Client, r gcResource) error {
+	switch r.Kind {
+	case "volume":
+		return client.DeleteVolume(ctx, r.ID)
+	case "nodebalancer":
+		return client.DeleteNodeBalancer(ctx, r.ID)
+	case "firewall":
+		return client.DeleteFirewall(ctx, r.ID)
+	case "bucket":
+		return client.DeleteObjectStorageBucket(ctx, r.Region, r.Label)
+	case "obj key":
+		return client.DeleteObjectStorageKey(ctx, r.ID)
+	}
+
+	return fmt.Errorf("unknown resource kind %q", r.Kind)
+}
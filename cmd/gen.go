@@ -131,6 +131,7 @@ func codegen(p *Project) {
 
 		data["org"] = viper.GetString("pulumiOrg")
 		data["cfgTplName"] = p.Name
+		data["storedir"] = filepath.Join(paths.Config, "secrets")
 
 		cmdPath := filepath.Join(p.Base, "cmd", i)
 		pkg := cmdPath + "/app"
@@ -159,6 +160,9 @@ func codegen(p *Project) {
 			}
 		case "infra":
 			data["cfgTplName"] = p.Name + "-infra"
+			data["lifecycleruleid"] = lifecycleRuleID
+			data["lifecycledefault"] = defaultLifecycle
+			data["bucketlifecycle"] = p.Data.bucketLifecycles()
 
 			if err := dirGen(pkg); err != nil {
 				logger.Error("creating infra pkg directories: " + err.Error())
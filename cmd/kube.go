@@ -0,0 +1,168 @@
+package cmd
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// kubeConfig is the subset of a kubeconfig file needed to call the kube api
+// with the token auth used by LKE.
+type kubeConfig struct {
+	CurrentContext string `yaml:"current-context"`
+	Clusters       []struct {
+		Name    string `yaml:"name"`
+		Cluster struct {
+			Server string `yaml:"server"`
+			CAData string `yaml:"certificate-authority-data"`
+		} `yaml:"cluster"`
+	} `yaml:"clusters"`
+	Contexts []struct {
+		Name    string `yaml:"name"`
+		Context struct {
+			Cluster string `yaml:"cluster"`
+			User    string `yaml:"user"`
+		} `yaml:"context"`
+	} `yaml:"contexts"`
+	Users []struct {
+		Name string `yaml:"name"`
+		User struct {
+			Token string `yaml:"token"`
+		} `yaml:"user"`
+	} `yaml:"users"`
+}
+
+type kubeClient struct {
+	client *http.Client
+	server string
+	token  string
+}
+
+// kubeconfigPath is where the infra stack writes the platform kubeconfig.
+func kubeconfigPath(name string) string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		logger.Error("locate user home directory: " + err.Error())
+	}
+
+	return filepath.Join(home, ".kube", name+"-kubeconfig.yaml")
+}
+
+// newKubeClient builds a kube api client from the current context of a
+// kubeconfig file.
+func newKubeClient(file string) (*kubeClient, error) {
+	var cfg kubeConfig
+
+	f, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := yaml.Unmarshal(f, &cfg); err != nil {
+		return nil, errors.New("yaml unmarshal kubeconfig: " + err.Error())
+	}
+
+	var clusterName, userName string
+
+	for _, i := range cfg.Contexts {
+		if i.Name == cfg.CurrentContext {
+			clusterName, userName = i.Context.Cluster, i.Context.User
+		}
+	}
+
+	kc := &kubeClient{}
+	pool := x509.NewCertPool()
+
+	for _, i := range cfg.Clusters {
+		if i.Name != clusterName {
+			continue
+		}
+
+		kc.server = i.Cluster.Server
+
+		ca, err := base64.StdEncoding.DecodeString(i.Cluster.CAData)
+		if err != nil {
+			return nil, errors.New("decode cluster ca: " + err.Error())
+		}
+
+		pool.AppendCertsFromPEM(ca)
+	}
+
+	for _, i := range cfg.Users {
+		if i.Name == userName {
+			kc.token = i.User.Token
+		}
+	}
+
+	if kc.server == "" || kc.token == "" {
+		return nil, errors.New("kubeconfig: no server or token found for the current context")
+	}
+
+	kc.client = &http.Client{
+		Timeout: 30 * time.Second,
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12},
+		},
+	}
+
+	return kc, nil
+}
+
+// get sends a GET request to a kube api path and decodes the JSON response
+// into v.
+func (kc *kubeClient) get(ctx context.Context, path string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, kc.server+path, nil)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Add("Accept", "application/json")
+	req.Header.Add("Authorization", "Bearer "+kc.token)
+
+	res, err := kc.client.Do(req)
+	if err != nil {
+		return err
+	}
+
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return errors.New("kube api: " + res.Status)
+	}
+
+	return json.NewDecoder(res.Body).Decode(v)
+}
+
+// secretData returns the decoded data of a kubernetes secret.
+func (kc *kubeClient) secretData(ctx context.Context, namespace, name string) (map[string]string, error) {
+	var secret struct {
+		Data map[string]string `json:"data"`
+	}
+
+	path := "/api/v1/namespaces/" + namespace + "/secrets/" + name
+	if err := kc.get(ctx, path, &secret); err != nil {
+		return nil, err
+	}
+
+	data := make(map[string]string, len(secret.Data))
+
+	for k, v := range secret.Data {
+		dec, err := base64.StdEncoding.DecodeString(v)
+		if err != nil {
+			return nil, errors.New("decode secret " + k + ": " + err.Error())
+		}
+
+		data[k] = string(dec)
+	}
+
+	return data, nil
+}
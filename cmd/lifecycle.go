@@ -0,0 +1,373 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+	"github.com/pulumi/pulumi/sdk/v3/go/auto"
+	"github.com/spf13/cobra"
+)
+
+// BucketLifecycle is the lifecycle rule of a platform bucket, set in the
+// platform config under the bucket name such as loki. Zero days use the
+// default policy and negative days turn a rule off.
+type BucketLifecycle struct {
+	ExpirationDays           int `yaml:"expirationdays,omitempty"           json:"expirationDays"`
+	NoncurrentExpirationDays int `yaml:"noncurrentexpirationdays,omitempty" json:"noncurrentExpirationDays"`
+	AbortMultipartDays       int `yaml:"abortmultipartdays,omitempty"       json:"abortMultipartDays"`
+}
+
+// lifecycleRuleID is the id of the platform rule, which the infra project
+// also uses for the rules it creates.
+const lifecycleRuleID = "global-expiration-policy"
+
+// defaultLifecycle is the lifecycle of buckets that have none in the
+// platform config. The infra project gets it at codegen.
+var defaultLifecycle = BucketLifecycle{ExpirationDays: 90, AbortMultipartDays: 5}
+
+var objLifecycleCmd = &cobra.Command{
+	Use:   "lifecycle",
+	Short: "Show and apply the lifecycle rules of platform buckets",
+}
+
+var objLifecycleShowCmd = &cobra.Command{
+	Use:    "show [bucket]",
+	Short:  "Show the live lifecycle rules of platform buckets",
+	Args:   cobra.MaximumNArgs(1),
+	PreRun: objStoreExists,
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx, cancel := context.WithTimeout(context.Background(), 1*time.Minute)
+		defer cancel()
+
+		r := platformObjRemote()
+
+		keys, err := r.bucketKeys(args)
+		if err != nil {
+			logger.Error("obj lifecycle show: " + err.Error())
+
+			return
+		}
+
+		client := r.s3Client()
+		drift := false
+
+		logger.Info("header:bucket lifecycle (live -> platform config)")
+
+		for _, k := range keys {
+			bucket := r.Buckets[k]
+
+			live, err := liveLifecycle(ctx, client, bucket)
+			if err != nil {
+				logger.Warn(fmt.Sprintf("lifecycle of bucket %s: %s", bucket, err.Error()))
+
+				continue
+			}
+
+			want := platform.bucketLifecycle(k)
+			line := fmt.Sprintf("%-40s %s", bucket, live)
+
+			if live != want {
+				drift = true
+				line += " -> " + want.String()
+			}
+
+			logger.Info("line:" + line)
+		}
+
+		fmt.Println() //nolint:forbidigo
+
+		if drift {
+			logger.Warn("live rules differ from the platform config: run 'obj lifecycle apply' to update them")
+		}
+	},
+}
+
+var objLifecycleApplyCmd = &cobra.Command{
+	Use:    "apply [bucket]",
+	Short:  "Set the lifecycle rules of platform buckets from the platform config",
+	Args:   cobra.MaximumNArgs(1),
+	PreRun: objStoreExists,
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+		defer cancel()
+
+		r := platformObjRemote()
+
+		keys, err := r.bucketKeys(args)
+		if err != nil {
+			logger.Error("obj lifecycle apply: " + err.Error())
+
+			return
+		}
+
+		client := r.s3Client()
+		changes := make(map[string]BucketLifecycle)
+		pins := make(map[string]BucketLifecycle)
+
+		for _, k := range keys {
+			bucket := r.Buckets[k]
+
+			live, err := liveLifecycle(ctx, client, bucket)
+			if err != nil {
+				logger.Error(fmt.Sprintf("lifecycle of bucket %s: %s", bucket, err.Error()))
+
+				return
+			}
+
+			want := platform.bucketLifecycle(k)
+			pins[k] = want
+
+			if live != want {
+				logger.Info("line:" + fmt.Sprintf("%-40s %s -> %s", bucket, live, want))
+				changes[bucket] = want
+			}
+		}
+
+		if len(changes) == 0 {
+			// pin the rules anyway, so a deploy keeps them when the infra
+			// stack was generated from an older platform config
+			if err := pinBucketLifecycle(ctx, pins); err != nil {
+				logger.Error("obj lifecycle apply: " + err.Error())
+			}
+
+			logger.Info("lifecycle rules match the platform config")
+
+			return
+		}
+
+		fmt.Println() //nolint:forbidigo
+
+		prompt := fmt.Sprintf("replace the lifecycle rules of %d bucket(s)? (type YES to confirm)", len(changes))
+		if ok := InputPrompt("warn", "YES", prompt); !ok {
+			logger.Warn("lifecycle apply cancelled")
+
+			return
+		}
+
+		// the infra project would otherwise put back the rules it was
+		// generated with on the next deploy
+		if err := pinBucketLifecycle(ctx, pins); err != nil {
+			logger.Error("obj lifecycle apply: " + err.Error())
+
+			return
+		}
+
+		for _, bucket := range slices.Sorted(maps.Keys(changes)) {
+			if err := putLifecycle(ctx, client, bucket, changes[bucket]); err != nil {
+				logger.Error(fmt.Sprintf("set lifecycle of bucket %s: %s", bucket, err.Error()))
+
+				continue
+			}
+
+			logger.Info("lifecycle of bucket " + bucket + " set to " + changes[bucket].String())
+		}
+	},
+}
+
+func init() {
+	objLifecycleCmd.AddCommand(objLifecycleApplyCmd, objLifecycleShowCmd)
+}
+
+// bucketLifecycle returns the lifecycle of a bucket from the platform config,
+// with defaults filled in and rules that are off set to zero days.
+func (p Platform) bucketLifecycle(key string) BucketLifecycle {
+	l := p.Lifecycle[key]
+
+	return BucketLifecycle{
+		ExpirationDays:           lifecycleDaysOrDefault(l.ExpirationDays, defaultLifecycle.ExpirationDays),
+		NoncurrentExpirationDays: lifecycleDaysOrDefault(l.NoncurrentExpirationDays, defaultLifecycle.NoncurrentExpirationDays),
+		AbortMultipartDays:       lifecycleDaysOrDefault(l.AbortMultipartDays, defaultLifecycle.AbortMultipartDays),
+	}
+}
+
+// bucketLifecycles returns the lifecycle of every bucket in the platform
+// config, for the infra project.
+func (p Platform) bucketLifecycles() map[string]BucketLifecycle {
+	res := make(map[string]BucketLifecycle, len(p.Lifecycle))
+
+	for k := range p.Lifecycle {
+		res[k] = p.bucketLifecycle(k)
+	}
+
+	return res
+}
+
+// pinBucketLifecycle merges bucket lifecycles into the bucketLifecycle infra
+// stack config, which the infra project prefers over the generated rules.
+func pinBucketLifecycle(ctx context.Context, pins map[string]BucketLifecycle) error {
+	stk := &MicroStack{Name: "infra"}
+	stk.Path = filepath.Join(paths.Projects, platform.Name, "cmd", stk.Name)
+	stk.GetFullName(ctx)
+
+	s := initLocalStack(ctx, stk)
+	cur := make(map[string]BucketLifecycle)
+
+	if v, err := s.GetConfig(ctx, "bucketLifecycle"); err == nil && v.Value != "" {
+		if err := json.Unmarshal([]byte(v.Value), &cur); err != nil {
+			return errors.New("json unmarshal bucketLifecycle: " + err.Error())
+		}
+	}
+
+	maps.Copy(cur, pins)
+
+	data, err := json.Marshal(cur)
+	if err != nil {
+		return err
+	}
+
+	if err := s.SetConfig(ctx, "bucketLifecycle", auto.ConfigValue{Value: string(data)}); err != nil {
+		return errors.New("set bucketLifecycle in infra stack config: " + err.Error())
+	}
+
+	return nil
+}
+
+func lifecycleDaysOrDefault(days, def int) int {
+	switch {
+	case days < 0:
+		return 0
+	case days == 0:
+		return def
+	}
+
+	return days
+}
+
+func (l BucketLifecycle) String() string {
+	days := func(n int) string {
+		if n == 0 {
+			return "off"
+		}
+
+		return fmt.Sprintf("%dd", n)
+	}
+
+	return fmt.Sprintf("expire %s, noncurrent %s, abort multipart %s",
+		days(l.ExpirationDays), days(l.NoncurrentExpirationDays), days(l.AbortMultipartDays))
+}
+
+// bucketKeys returns the objBuckets keys of the bucket in args, or of every
+// platform bucket when args is empty.
+func (r *s3Remote) bucketKeys(args []string) ([]string, error) {
+	if len(args) == 0 {
+		return slices.Sorted(maps.Keys(r.Buckets)), nil
+	}
+
+	bucket, err := r.bucket(args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	for k, v := range r.Buckets {
+		if v == bucket {
+			return []string{k}, nil
+		}
+	}
+
+	return nil, errors.New("bucket " + bucket + " not found")
+}
+
+// s3Client returns an S3 API client for the endpoint of the remote. Checksums
+// are only sent when an operation requires them, which S3 compatible object
+// storage expects.
+func (r *s3Remote) s3Client() *s3.Client {
+	region, _, _ := strings.Cut(r.Endpoint, ".")
+
+	return s3.New(s3.Options{
+		BaseEndpoint:               aws.String("https://" + r.Endpoint),
+		Credentials:                credentials.NewStaticCredentialsProvider(r.AccessKeyId, r.SecretAccessKey, ""),
+		Region:                     region,
+		RequestChecksumCalculation: aws.RequestChecksumCalculationWhenRequired,
+		ResponseChecksumValidation: aws.ResponseChecksumValidationWhenRequired,
+	})
+}
+
+// liveLifecycle reads the platform rule of a bucket. Rules under other ids
+// are ignored, and a bucket without a lifecycle configuration has every rule
+// off.
+func liveLifecycle(ctx context.Context, client *s3.Client, bucket string) (BucketLifecycle, error) {
+	var l BucketLifecycle
+
+	res, err := client.GetBucketLifecycleConfiguration(ctx, &s3.GetBucketLifecycleConfigurationInput{
+		Bucket: aws.String(bucket),
+	})
+	if err != nil {
+		var apiErr smithy.APIError
+		if errors.As(err, &apiErr) && apiErr.ErrorCode() == "NoSuchLifecycleConfiguration" {
+			return l, nil
+		}
+
+		return l, err
+	}
+
+	for _, i := range res.Rules {
+		if aws.ToString(i.ID) != lifecycleRuleID || i.Status != types.ExpirationStatusEnabled {
+			continue
+		}
+
+		if i.Expiration != nil {
+			l.ExpirationDays = int(aws.ToInt32(i.Expiration.Days))
+		}
+
+		if i.NoncurrentVersionExpiration != nil {
+			l.NoncurrentExpirationDays = int(aws.ToInt32(i.NoncurrentVersionExpiration.NoncurrentDays))
+		}
+
+		if i.AbortIncompleteMultipartUpload != nil {
+			l.AbortMultipartDays = int(aws.ToInt32(i.AbortIncompleteMultipartUpload.DaysAfterInitiation))
+		}
+	}
+
+	return l, nil
+}
+
+// putLifecycle replaces the lifecycle configuration of a bucket with the
+// platform rule, or deletes it when every rule is off.
+func putLifecycle(ctx context.Context, client *s3.Client, bucket string, l BucketLifecycle) error {
+	if l == (BucketLifecycle{}) {
+		_, err := client.DeleteBucketLifecycle(ctx, &s3.DeleteBucketLifecycleInput{Bucket: aws.String(bucket)})
+
+		return err
+	}
+
+	rule := types.LifecycleRule{
+		ID:     aws.String(lifecycleRuleID),
+		Status: types.ExpirationStatusEnabled,
+		Filter: &types.LifecycleRuleFilter{Prefix: aws.String("")},
+	}
+
+	if l.ExpirationDays > 0 {
+		rule.Expiration = &types.LifecycleExpiration{Days: aws.Int32(int32(l.ExpirationDays))}
+	}
+
+	if l.NoncurrentExpirationDays > 0 {
+		rule.NoncurrentVersionExpiration = &types.NoncurrentVersionExpiration{
+			NoncurrentDays: aws.Int32(int32(l.NoncurrentExpirationDays)),
+		}
+	}
+
+	if l.AbortMultipartDays > 0 {
+		rule.AbortIncompleteMultipartUpload = &types.AbortIncompleteMultipartUpload{
+			DaysAfterInitiation: aws.Int32(int32(l.AbortMultipartDays)),
+		}
+	}
+
+	_, err := client.PutBucketLifecycleConfiguration(ctx, &s3.PutBucketLifecycleConfigurationInput{
+		Bucket:                 aws.String(bucket),
+		LifecycleConfiguration: &types.BucketLifecycleConfiguration{Rules: []types.LifecycleRule{rule}},
+	})
+
+	return err
+}
@@ -0,0 +1,187 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+const pulumiAPI = "https://api.pulumi.com/api"
+
+var listTags []string
+
+type pulumiStack struct {
+	OrgName       string            `json:"orgName"`
+	ProjectName   string            `json:"projectName"`
+	StackName     string            `json:"stackName"`
+	LastUpdate    int64             `json:"lastUpdate,omitempty"`
+	ResourceCount int               `json:"resourceCount,omitempty"`
+	Tags          map[string]string `json:"tags,omitempty"`
+}
+
+type pulumiStackList struct {
+	Stacks            []pulumiStack `json:"stacks"`
+	ContinuationToken *string       `json:"continuationToken,omitempty"`
+}
+
+var listCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List platform stacks by tag",
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+		defer cancel()
+
+		filters := make(map[string]string)
+
+		for _, i := range listTags {
+			k, v, ok := strings.Cut(i, "=")
+			if !ok {
+				logger.Error("invalid tag filter (want key=value): " + i)
+
+				return
+			}
+
+			filters[k] = v
+		}
+
+		// every aplcli stack carries a platform tag
+		tagName, tagValue := "platform", ""
+		for k, v := range filters {
+			tagName, tagValue = k, v
+
+			break
+		}
+
+		stacks, err := listStacks(ctx, viper.GetString("pulumiOrg"), tagName, tagValue)
+		if err != nil {
+			logger.Error("list pulumi stacks: " + err.Error())
+
+			return
+		}
+
+		logger.Info("header:stacks")
+
+		count := 0
+
+		for _, i := range stacks {
+			stk, err := getStack(ctx, i.OrgName, i.ProjectName, i.StackName)
+			if err != nil {
+				logger.Error("get pulumi stack: " + err.Error())
+
+				continue
+			}
+
+			if !matchTags(stk.Tags, filters) {
+				continue
+			}
+
+			count++
+			fqsn := fmt.Sprintf("%s/%s/%s", i.OrgName, i.ProjectName, i.StackName)
+			line := fmt.Sprintf("%-40s platform=%s region=%s owner=%s resources=%d",
+				fqsn, stk.Tags["platform"], stk.Tags["region"], stk.Tags["owner"], i.ResourceCount)
+			logger.Info("line:" + line)
+		}
+
+		fmt.Println() //nolint:forbidigo
+
+		msg := fmt.Sprintf("%d stacks found", count)
+		logger.Info(msg)
+	},
+}
+
+func init() {
+	// optional flags
+	listCmd.Flags().StringArrayVarP(&listTags, "tag", "", []string{}, "Filter by stack tag (key=value)")
+
+	_ = viper.BindPFlags(listCmd.LocalFlags())
+}
+
+func matchTags(tags, filters map[string]string) bool {
+	for k, v := range filters {
+		if tags[k] != v {
+			return false
+		}
+	}
+
+	return true
+}
+
+// listStacks pages through the stacks of an org, filtered server side by a
+// single tag. An empty tag value matches any stack with the tag set.
+func listStacks(ctx context.Context, org, tagName, tagValue string) ([]pulumiStack, error) {
+	var stacks []pulumiStack
+
+	query := url.Values{}
+	query.Set("organization", org)
+	query.Set("tagName", tagName)
+
+	if tagValue != "" {
+		query.Set("tagValue", tagValue)
+	}
+
+	for {
+		var res pulumiStackList
+
+		if err := pulumiGet(ctx, "/user/stacks?"+query.Encode(), &res); err != nil {
+			return nil, err
+		}
+
+		stacks = append(stacks, res.Stacks...)
+
+		if res.ContinuationToken == nil || *res.ContinuationToken == "" {
+			break
+		}
+
+		query.Set("continuationToken", *res.ContinuationToken)
+	}
+
+	return stacks, nil
+}
+
+func getStack(ctx context.Context, org, proj, stack string) (pulumiStack, error) {
+	var stk pulumiStack
+
+	path := fmt.Sprintf("/stacks/%s/%s/%s", org, proj, stack)
+	err := pulumiGet(ctx, path, &stk)
+
+	return stk, err
+}
+
+// pulumiGet sends an authenticated GET request to the Pulumi Cloud REST API
+// and decodes the JSON response into v.
+func pulumiGet(ctx context.Context, path string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, pulumiAPI+path, nil)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Add("Accept", "application/vnd.pulumi+8")
+	req.Header.Add("Content-Type", "application/json")
+	req.Header.Add("Authorization", "token "+os.Getenv("PULUMI_ACCESS_TOKEN"))
+
+	httpAPIClient := &http.Client{
+		Timeout: time.Second * 30,
+	}
+
+	res, err := httpAPIClient.Do(req)
+	if err != nil {
+		return err
+	}
+
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return errors.New("pulumi api: " + res.Status)
+	}
+
+	return json.NewDecoder(res.Body).Decode(v)
+}
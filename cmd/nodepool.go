@@ -0,0 +1,296 @@
+package cmd
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/auto"
+	"github.com/spf13/cobra"
+)
+
+// NodePoolConfig is an LKE node pool, set in the platform config under
+// nodePools. Pools with a max are autoscaled between min and max.
+type NodePoolConfig struct {
+	Count  int               `yaml:"count,omitempty"  json:"count"`
+	Labels map[string]string `yaml:"labels,omitempty" json:"labels,omitempty"`
+	Max    int               `yaml:"max,omitempty"    json:"max"`
+	Min    int               `yaml:"min,omitempty"    json:"min"`
+	Name   string            `yaml:"name"             json:"name"`
+	Tags   []string          `yaml:"tags,omitempty"   json:"tags,omitempty"`
+	Taints []NodeTaint       `yaml:"taints,omitempty" json:"taints,omitempty"`
+	Type   string            `yaml:"type,omitempty"   json:"type"`
+}
+
+// NodeTaint is a kubernetes taint on the nodes of a pool.
+type NodeTaint struct {
+	Effect string `yaml:"effect"          json:"effect"`
+	Key    string `yaml:"key"             json:"key"`
+	Value  string `yaml:"value,omitempty" json:"value"`
+}
+
+var taintEffects = []string{"NoSchedule", "PreferNoSchedule", "NoExecute"}
+
+var (
+	nodePoolCount  int
+	nodePoolLabels map[string]string
+	nodePoolMax    int
+	nodePoolMin    int
+	nodePoolTags   []string
+	nodePoolTaints []string
+	nodePoolType   string
+)
+
+var nodePoolCmd = &cobra.Command{
+	Use:   "nodepool",
+	Short: "Scale, add and remove LKE node pools",
+}
+
+var nodePoolScaleCmd = &cobra.Command{
+	Use:   "scale <pool>",
+	Short: "Change the node count or autoscaler limits of a node pool",
+	Args:  cobra.ExactArgs(1),
+	PreRun: func(cmd *cobra.Command, args []string) {
+		if !cmd.Flags().Changed("count") && !cmd.Flags().Changed("min") && !cmd.Flags().Changed("max") {
+			logger.Error("nodepool scale: one of --count, --min or --max is required")
+			os.Exit(1)
+		}
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := context.Background()
+		stk := infraStack(ctx)
+		s := initLocalStack(ctx, stk)
+		pools := stackNodePools(ctx, s)
+
+		idx := slices.IndexFunc(pools, func(p NodePoolConfig) bool { return p.Name == args[0] })
+		if idx < 0 {
+			logger.Error("nodepool scale: node pool " + args[0] + " not found")
+
+			return
+		}
+
+		pool := &pools[idx]
+		if cmd.Flags().Changed("count") {
+			pool.Count = nodePoolCount
+		}
+
+		if cmd.Flags().Changed("min") {
+			pool.Min = nodePoolMin
+		}
+
+		if cmd.Flags().Changed("max") {
+			pool.Max = nodePoolMax
+		}
+
+		if err := pool.validate(); err != nil {
+			logger.Error("nodepool scale: " + err.Error())
+
+			return
+		}
+
+		if err := applyNodePools(ctx, stk, s, pools, "scale node pool "+pool.String()); err != nil {
+			logger.Error("nodepool scale: " + err.Error())
+		}
+	},
+}
+
+var nodePoolAddCmd = &cobra.Command{
+	Use:   "add <pool>",
+	Short: "Add a node pool to the LKE cluster",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := context.Background()
+		stk := infraStack(ctx)
+		s := initLocalStack(ctx, stk)
+		pools := stackNodePools(ctx, s)
+
+		if slices.ContainsFunc(pools, func(p NodePoolConfig) bool { return p.Name == args[0] }) {
+			logger.Error("nodepool add: node pool " + args[0] + " already exists")
+
+			return
+		}
+
+		pool := NodePoolConfig{
+			Count:  nodePoolCount,
+			Labels: nodePoolLabels,
+			Max:    nodePoolMax,
+			Min:    nodePoolMin,
+			Name:   args[0],
+			Tags:   nodePoolTags,
+			Type:   nodePoolType,
+		}
+
+		for _, i := range nodePoolTaints {
+			taint, err := parseTaint(i)
+			if err != nil {
+				logger.Error("nodepool add: " + err.Error())
+
+				return
+			}
+
+			pool.Taints = append(pool.Taints, taint)
+		}
+
+		if err := pool.validate(); err != nil {
+			logger.Error("nodepool add: " + err.Error())
+
+			return
+		}
+
+		pools = append(pools, pool)
+		if err := applyNodePools(ctx, stk, s, pools, "add node pool "+pool.String()); err != nil {
+			logger.Error("nodepool add: " + err.Error())
+		}
+	},
+}
+
+var nodePoolRemoveCmd = &cobra.Command{
+	Use:   "remove <pool>",
+	Short: "Remove a node pool and its nodes from the LKE cluster",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := context.Background()
+		stk := infraStack(ctx)
+		s := initLocalStack(ctx, stk)
+		pools := stackNodePools(ctx, s)
+
+		idx := slices.IndexFunc(pools, func(p NodePoolConfig) bool { return p.Name == args[0] })
+		if idx < 0 {
+			logger.Error("nodepool remove: node pool " + args[0] + " not found")
+
+			return
+		}
+
+		if len(pools) == 1 {
+			logger.Error("nodepool remove: " + args[0] + " is the last node pool of the cluster")
+
+			return
+		}
+
+		pool := pools[idx]
+		pools = slices.Delete(pools, idx, idx+1)
+
+		if err := applyNodePools(ctx, stk, s, pools, "remove node pool "+pool.String()); err != nil {
+			logger.Error("nodepool remove: " + err.Error())
+		}
+	},
+}
+
+func init() {
+	// required flags
+	nodePoolCmd.PersistentFlags().StringVarP(&platform.Name, "name", "n", "", "APL instance name (required)")
+	nodePoolCmd.MarkPersistentFlagRequired("name") //nolint:errcheck
+	nodePoolAddCmd.Flags().StringVarP(&nodePoolType, "type", "", "", "Node pool instance type (required)")
+	nodePoolAddCmd.MarkFlagRequired("type") //nolint:errcheck
+	// optional flags
+	for _, i := range []*cobra.Command{nodePoolAddCmd, nodePoolScaleCmd} {
+		i.Flags().IntVarP(&nodePoolCount, "count", "", 3, "Node count")
+		i.Flags().IntVarP(&nodePoolMin, "min", "", 0, "Autoscaler min, defaults to the node count")
+		i.Flags().IntVarP(&nodePoolMax, "max", "", 0, "Autoscaler max, 0 turns the autoscaler off")
+	}
+
+	nodePoolAddCmd.Flags().StringToStringVarP(&nodePoolLabels, "label", "", nil, "Node label as key=value")
+	nodePoolAddCmd.Flags().StringArrayVarP(&nodePoolTaints, "taint", "", nil, "Node taint as key=value:Effect")
+	nodePoolAddCmd.Flags().StringSliceVarP(&nodePoolTags, "tag", "", nil, "Node tag")
+
+	nodePoolCmd.AddCommand(nodePoolAddCmd, nodePoolRemoveCmd, nodePoolScaleCmd)
+}
+
+// stackNodePools returns the node pools pinned in stack config, falling back
+// to the platform config and finally a single default pool, the same way the
+// infra project does.
+func stackNodePools(ctx context.Context, s auto.Stack) []NodePoolConfig {
+	fallback := func() []NodePoolConfig {
+		if len(platform.NodePools) > 0 {
+			return slices.Clone(platform.NodePools)
+		}
+
+		return []NodePoolConfig{{
+			Count: cmp.Or(platform.NodeCount, 3),
+			Max:   cmp.Or(platform.NodeMax, 15),
+			Name:  "default",
+			Type:  cmp.Or(platform.NodeType, "g6-dedicated-8"),
+		}}
+	}
+
+	v, err := s.GetConfig(ctx, "nodePools")
+	if err != nil || v.Value == "" {
+		return fallback()
+	}
+
+	var pools []NodePoolConfig
+	if err := json.Unmarshal([]byte(v.Value), &pools); err != nil {
+		logger.Warn("json unmarshal nodePools: " + err.Error())
+
+		return fallback()
+	}
+
+	return pools
+}
+
+// applyNodePools pins the node pools in infra stack config as nodePools,
+// which the infra project prefers over the platform config, and deploys the
+// infra stack.
+func applyNodePools(ctx context.Context, stk *MicroStack, s auto.Stack, pools []NodePoolConfig, change string) error {
+	prompt := change + "? (type YES to confirm)"
+	if ok := InputPrompt("warn", "YES", prompt); !ok {
+		logger.Warn("node pool update cancelled")
+
+		return nil
+	}
+
+	data, err := json.Marshal(pools)
+	if err != nil {
+		return err
+	}
+
+	if err := s.SetConfig(ctx, "nodePools", auto.ConfigValue{Value: string(data)}); err != nil {
+		return errors.New("set nodePools in infra stack config: " + err.Error())
+	}
+
+	stk.Up(ctx)
+
+	return nil
+}
+
+func (p NodePoolConfig) validate() error {
+	switch {
+	case p.Count < 1:
+		return errors.New("node count must be at least 1")
+	case p.Max > 0 && p.Max < max(p.Min, p.Count):
+		return fmt.Errorf("autoscaler max %d is below the min or node count", p.Max)
+	case p.Min > 0 && p.Max == 0:
+		return errors.New("autoscaler min needs a max")
+	}
+
+	return nil
+}
+
+func (p NodePoolConfig) String() string {
+	s := fmt.Sprintf("%s (%s, %d nodes", p.Name, cmp.Or(p.Type, "default type"), p.Count)
+	if p.Max > 0 {
+		s += fmt.Sprintf(", autoscale %d-%d", cmp.Or(p.Min, p.Count), p.Max)
+	}
+
+	return s + ")"
+}
+
+// parseTaint parses a taint written as key=value:Effect, like kubectl does.
+func parseTaint(s string) (NodeTaint, error) {
+	kv, effect, ok := strings.Cut(s, ":")
+	if !ok || !slices.Contains(taintEffects, effect) {
+		return NodeTaint{}, fmt.Errorf("taint %q needs an effect of %s", s, strings.Join(taintEffects, ", "))
+	}
+
+	key, value, _ := strings.Cut(kv, "=")
+	if key == "" {
+		return NodeTaint{}, fmt.Errorf("taint %q needs a key", s)
+	}
+
+	return NodeTaint{Effect: effect, Key: key, Value: value}, nil
+}
@@ -0,0 +1,243 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+var objCmd = &cobra.Command{
+	Use:   "obj",
+	Short: "List, measure, sync and restore platform buckets and manage their lifecycle",
+}
+
+var objLsCmd = &cobra.Command{
+	Use:    "ls [bucket]",
+	Short:  "List platform buckets, or the objects in a bucket",
+	Args:   cobra.MaximumNArgs(1),
+	PreRun: objStoreExists,
+	Run: func(cmd *cobra.Command, args []string) {
+		r := platformObjRemote()
+
+		if len(args) == 0 {
+			logger.Info("header:buckets")
+
+			for _, k := range slices.Sorted(maps.Keys(r.Buckets)) {
+				logger.Info("line:" + fmt.Sprintf("%-12s %s", k, r.Buckets[k]))
+			}
+
+			fmt.Println() //nolint:forbidigo
+
+			return
+		}
+
+		bucket, err := r.bucket(args[0])
+		if err != nil {
+			logger.Error("obj ls: " + err.Error())
+
+			return
+		}
+
+		files, err := rcloneListFiles(r.Fs(bucket))
+		if err != nil {
+			logger.Error("list bucket " + bucket + ": " + err.Error())
+
+			return
+		}
+
+		logger.Info("header:" + bucket)
+
+		for _, i := range files {
+			logger.Info("line:" + fmt.Sprintf("%12s  %-30s %s", formatBytes(i.Size), i.ModTime, i.Path))
+		}
+
+		fmt.Println() //nolint:forbidigo
+
+		msg := fmt.Sprintf("%d objects found", len(files))
+		logger.Info(msg)
+	},
+}
+
+var objDuCmd = &cobra.Command{
+	Use:    "du",
+	Short:  "Show the size and object count of each platform bucket",
+	Args:   cobra.NoArgs,
+	PreRun: objStoreExists,
+	Run: func(cmd *cobra.Command, args []string) {
+		r := platformObjRemote()
+
+		var (
+			totalCount int64
+			totalBytes int64
+		)
+
+		logger.Info("header:bucket usage")
+
+		for _, k := range slices.Sorted(maps.Keys(r.Buckets)) {
+			bucket := r.Buckets[k]
+
+			count, size, err := rcloneSize(r.Fs(bucket))
+			if err != nil {
+				logger.Warn(fmt.Sprintf("size of bucket %s: %s", bucket, err.Error()))
+
+				continue
+			}
+
+			totalCount += count
+			totalBytes += size
+
+			logger.Info("line:" + fmt.Sprintf("%-40s %10d objects %12s", bucket, count, formatBytes(size)))
+		}
+
+		logger.Info("line:" + fmt.Sprintf("%-40s %10d objects %12s", "total", totalCount, formatBytes(totalBytes)))
+		fmt.Println() //nolint:forbidigo
+	},
+}
+
+var objSyncCmd = &cobra.Command{
+	Use:    "sync <bucket> <dst>",
+	Short:  "Make an rclone remote:path or local directory match a platform bucket",
+	Args:   cobra.ExactArgs(2),
+	PreRun: objStoreExists,
+	Run: func(cmd *cobra.Command, args []string) {
+		r := platformObjRemote()
+
+		bucket, err := r.bucket(args[0])
+		if err != nil {
+			logger.Error("obj sync: " + err.Error())
+
+			return
+		}
+
+		if err := objTransfer("sync/sync", r.Fs(bucket), args[1], bucket+" -> "+args[1]); err != nil {
+			logger.Error("sync bucket " + bucket + ": " + err.Error())
+
+			return
+		}
+
+		logger.Info("bucket " + bucket + " synced to " + args[1])
+	},
+}
+
+var objRestoreCmd = &cobra.Command{
+	Use:    "restore <src> <bucket>",
+	Short:  "Copy objects from an rclone remote:path or local directory into a platform bucket",
+	Args:   cobra.ExactArgs(2),
+	PreRun: objStoreExists,
+	Run: func(cmd *cobra.Command, args []string) {
+		r := platformObjRemote()
+
+		bucket, err := r.bucket(args[1])
+		if err != nil {
+			logger.Error("obj restore: " + err.Error())
+
+			return
+		}
+
+		prompt := fmt.Sprintf("overwrite objects in bucket %s with %s? (type YES to confirm)", bucket, args[0])
+		if ok := InputPrompt("warn", "YES", prompt); !ok {
+			logger.Warn("restore cancelled")
+
+			return
+		}
+
+		// copy leaves objects that only exist in the bucket in place
+		if err := objTransfer("sync/copy", args[0], r.Fs(bucket), args[0]+" -> "+bucket); err != nil {
+			logger.Error("restore bucket " + bucket + ": " + err.Error())
+
+			return
+		}
+
+		logger.Info("bucket " + bucket + " restored from " + args[0])
+	},
+}
+
+func init() {
+	// required flags
+	objCmd.PersistentFlags().StringVarP(&platform.Name, "name", "n", "", "APL instance name (required)")
+	objCmd.MarkPersistentFlagRequired("name") //nolint:errcheck
+
+	objCmd.AddCommand(objDuCmd, objLifecycleCmd, objLsCmd, objRestoreCmd, objSyncCmd)
+}
+
+// objStoreExists stops obj commands for platforms without a secret store,
+// which holds the bucket names and key.
+func objStoreExists(cmd *cobra.Command, args []string) {
+	if ok := platformStore().Exists(); !ok {
+		logger.Error("secret store not found: run 'create' command first")
+		os.Exit(0)
+	}
+}
+
+// platformObjRemote returns an initialized s3Remote for the platform buckets,
+// with the bucket names and key read from the secret store.
+func platformObjRemote() s3Remote {
+	store := platformStore()
+
+	r, err := Get[s3Remote](store, "apl:objKey")
+	if err != nil {
+		logger.Error("get obj key from secret store: " + err.Error())
+		os.Exit(1)
+	}
+
+	if r.Buckets, err = Get[map[string]string](store, "apl:objBuckets"); err != nil {
+		logger.Error("get obj buckets from secret store: " + err.Error())
+		os.Exit(1)
+	}
+
+	r.Endpoint = platform.Region + "-1.linodeobjects.com"
+	r.Remote = platform.Name
+	r.Init(context.Background())
+
+	return r
+}
+
+// bucket resolves a bucket label or its objBuckets key, such as loki, to the
+// label. Only platform buckets are accepted.
+func (r *s3Remote) bucket(name string) (string, error) {
+	if v, ok := r.Buckets[name]; ok {
+		return v, nil
+	}
+
+	for _, v := range r.Buckets {
+		if v == name {
+			return v, nil
+		}
+	}
+
+	keys := slices.Sorted(maps.Keys(r.Buckets))
+
+	return "", fmt.Errorf("%q is not a platform bucket: wants one of %s", name, strings.Join(keys, ", "))
+}
+
+// objTransfer runs an rclone sync/sync or sync/copy job between two fs and
+// reports its progress under label, since the fs hold credentials.
+func objTransfer(method, src, dst, label string) error {
+	group := "obj/" + strings.TrimPrefix(method, "sync/")
+	req := syncRequest{
+		SrcFs: src,
+		DstFs: dst,
+		Group: group,
+		Async: true,
+	}
+
+	stats, err := rcloneJob(context.Background(), method, req, group, func(st rcloneStats) {
+		msg := fmt.Sprintf("%s: %s/%s, %d/%d objects", label,
+			formatBytes(st.Bytes), formatBytes(st.TotalBytes), st.Transfers, st.TotalTransfers)
+		logger.Info(msg)
+	})
+	if err != nil {
+		return err
+	}
+
+	if stats.Errors > 0 {
+		return fmt.Errorf("%d objects failed to transfer", stats.Errors)
+	}
+
+	return nil
+}
@@ -162,7 +162,8 @@ func init() {
 	rootCmd.PersistentFlags().SetNormalizeFunc(nameNormalizeFunc)
 
 	// subcommands
-	rootCmd.AddCommand(createCmd, deployCmd, destroyCmd, initCmd)
+	rootCmd.AddCommand(aclCmd, adoptCmd, createCmd, deployCmd, destroyCmd, envCmd, firewallCmd, gcCmd, initCmd,
+		listCmd, nodePoolCmd, objCmd, rotateCmd, secretsCmd, sopsCmd, upgradeCmd)
 
 	// usage func
 	helpText(rootCmd)
@@ -0,0 +1,399 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/linode/linodego"
+	"github.com/pulumi/pulumi/sdk/v3/go/auto"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+const objKeyType = "linode:index/objectStorageKey:ObjectStorageKey"
+
+var (
+	aplPasswords    = []string{"developTeamPass", "lokiAdminPass", "otomiAdminPass"}
+	rotateReencrypt []string
+	rotateSecret    string
+	rotateSecrets   = append(slices.Clone(aplPasswords), "age", "objKey", "linodeToken")
+)
+
+var rotateCmd = &cobra.Command{
+	Use:   "rotate",
+	Short: "Rotate generated passwords, keys and tokens",
+	PreRun: func(cmd *cobra.Command, args []string) {
+		if ok := platformStore().Exists(); !ok {
+			logger.Error("secret store not found: run 'create' command first")
+			os.Exit(0)
+		}
+
+		if rotateSecret != "" && !slices.Contains(rotateSecrets, rotateSecret) {
+			logger.Error("rotate: --secret must be one of " + strings.Join(rotateSecrets, ", "))
+			os.Exit(1)
+		}
+
+		// files encrypted to the current age key cannot be read once it is
+		// replaced, so they are re-encrypted in the same run
+		if rotateSecret == "age" && len(rotateReencrypt) == 0 {
+			logger.Error("rotate: --secret age needs --reencrypt with the sops files encrypted to the current key")
+			os.Exit(1)
+		}
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := context.Background()
+		store := platformStore()
+		stacks := StackMap{
+			1: {Name: "infra", PostRun: []string{"addNodeBalancerId"}},
+			2: {Name: "apl"},
+		}
+
+		for _, i := range stacks {
+			i.Path = filepath.Join(paths.Projects, platform.Name, "cmd", i.Name)
+			i.GetFullName(ctx)
+		}
+
+		secrets := aplPasswords
+		if rotateSecret != "" {
+			secrets = []string{rotateSecret}
+		}
+
+		if rotateSecret == "age" {
+			logger.Warn("sops files re-encrypted to the new age key: " + strings.Join(rotateReencrypt, ", "))
+		}
+
+		prompt := fmt.Sprintf("rotate %s? (type YES to confirm)", strings.Join(secrets, ", "))
+		if ok := InputPrompt("warn", "YES", prompt); !ok {
+			logger.Warn("rotation cancelled")
+
+			return
+		}
+
+		var (
+			err      error
+			verifyFn func() error
+		)
+
+		switch rotateSecret {
+		case "age":
+			err = rotateAgeKey(store, rotateReencrypt)
+		case "linodeToken":
+			verifyFn, err = rotateLinodeToken(ctx, store)
+		case "objKey":
+			verifyFn, err = rotateObjKey(ctx, store, stacks[1])
+		default:
+			err = rotatePasswords(store, secrets)
+		}
+
+		if err != nil {
+			logger.Error("rotate " + strings.Join(secrets, ", ") + ": " + err.Error())
+
+			return
+		}
+
+		// push the new values into the helm values of the apl chart
+		stacks[2].Up(ctx)
+
+		if verifyFn == nil {
+			logger.Info("rotated: the old value cannot be checked automatically")
+
+			return
+		}
+
+		if err := verifyFn(); err != nil {
+			logger.Error("verify rotation: " + err.Error())
+
+			return
+		}
+
+		logger.Info("rotated: the old credential no longer works")
+	},
+}
+
+func init() {
+	// required flags
+	rotateCmd.Flags().StringVarP(&platform.Name, "name", "n", "", "APL instance name (required)")
+	rotateCmd.MarkFlagRequired("name") //nolint:errcheck
+	// optional flags
+	rotateCmd.Flags().StringVarP(&rotateSecret, "secret", "", "",
+		"Secret to rotate: "+strings.Join(rotateSecrets, "|")+" (default is all admin passwords)")
+	rotateCmd.Flags().StringSliceVarP(&rotateReencrypt, "reencrypt", "", nil,
+		"Sops file to re-encrypt to the new age key (required with --secret age)")
+
+	_ = viper.BindPFlags(rotateCmd.LocalFlags())
+}
+
+// rotatePasswords replaces the named admin passwords, carrying the others over
+// unchanged since the aplSecrets value is written as a whole.
+func rotatePasswords(store SecretStore, names []string) error {
+	cur, err := store.Get("apl:secrets")
+	if err != nil {
+		return err
+	}
+
+	current, ok := cur.(map[string]any)
+	if !ok {
+		return errors.New("type assertion failed: wants map[string]any")
+	}
+
+	secMap := make(map[string]any, len(current))
+
+	for k, v := range current {
+		secMap[k] = FnSecret(fmt.Sprint(v))
+	}
+
+	for _, i := range names {
+		secMap[i] = FnSecret(Passgen())
+	}
+
+	env := NewEnvObject(viper.GetString("pulumiOrg"), platform.Name, platform.Stack)
+	env.Confirm = true
+	env.Items = map[int]EscEnvItem{
+		1: {Name: "aplSecrets", Value: secMap},
+	}
+
+	return store.Update(&env)
+}
+
+// rotateAgeKey replaces the age key and re-encrypts the sops files to it. The
+// files are decrypted and encrypted again before the key is replaced, and
+// written next to the originals until the new key is stored, so a failure at
+// any step leaves them readable with one of the keys.
+func rotateAgeKey(store SecretStore, files []string) error {
+	oldKey, err := Get[string](store, "apl:age.privateKey")
+	if err != nil {
+		return errors.New("get age private key: " + err.Error())
+	}
+
+	ageKeys, err := GenAgeKeys()
+	if err != nil {
+		return err
+	}
+
+	tmpFiles := make(map[string]string, len(files))
+
+	defer func() {
+		for _, tmp := range tmpFiles {
+			os.Remove(tmp) //nolint:errcheck
+		}
+	}()
+
+	for _, file := range files {
+		in, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+
+		plain, err := sopsDecrypt(in, oldKey)
+		if err != nil {
+			return errors.New("decrypt " + file + ": " + err.Error())
+		}
+
+		out, err := sopsEncrypt(plain, ageKeys.Recipient().String())
+		if err != nil {
+			return errors.New("encrypt " + file + ": " + err.Error())
+		}
+
+		info, err := os.Stat(file)
+		if err != nil {
+			return err
+		}
+
+		tmp := file + ".rotate"
+		if err := os.WriteFile(tmp, out, info.Mode().Perm()); err != nil {
+			return err
+		}
+
+		tmpFiles[file] = tmp
+	}
+
+	env := NewEnvObject(viper.GetString("pulumiOrg"), platform.Name, platform.Stack)
+	env.Confirm = true
+	env.Items = map[int]EscEnvItem{
+		1: {Name: "age", Value: map[string]any{
+			"publicKey":  ageKeys.Recipient().String(),
+			"privateKey": FnSecret(ageKeys.String()),
+		}},
+	}
+
+	if err := store.Update(&env); err != nil {
+		return err
+	}
+
+	// the new key is stored: files that cannot be replaced keep their copy
+	// encrypted to it
+	pending := tmpFiles
+	tmpFiles = nil
+
+	var errs []error
+
+	for file, tmp := range pending {
+		if err := os.Rename(tmp, file); err != nil {
+			errs = append(errs, fmt.Errorf("replace %s: %w: it is encrypted to the new key in %s", file, err, tmp))
+
+			continue
+		}
+
+		logger.Info("re-encrypted to the new age key: " + file)
+	}
+
+	return errors.Join(errs...)
+}
+
+// rotateLinodeToken creates a token with the scopes and expiry of the current
+// one and stores it. The returned func revokes the old token once the stacks
+// use the new one, then checks that it is rejected.
+func rotateLinodeToken(ctx context.Context, store SecretStore) (func() error, error) {
+	v, err := store.Get("linode:token")
+	if err != nil {
+		return nil, err
+	}
+
+	oldToken, ok := v.(string)
+	if !ok || len(oldToken) < 16 {
+		return nil, errors.New("linode token not found in secret store")
+	}
+
+	client := newLinodeClient(oldToken)
+
+	tokens, err := client.ListTokens(ctx, &linodego.ListOptions{})
+	if err != nil {
+		return nil, errors.New("list linode tokens: " + err.Error())
+	}
+
+	// only the first 16 characters of a token are returned by the api
+	idx := slices.IndexFunc(tokens, func(t linodego.Token) bool {
+		return t.Token != "" && strings.HasPrefix(oldToken, t.Token)
+	})
+	if idx < 0 {
+		return nil, errors.New("current token is not a personal access token of this profile")
+	}
+
+	old := tokens[idx]
+
+	created, err := client.CreateToken(ctx, linodego.TokenCreateOptions{
+		Label:  fmt.Sprintf("aplcli-%s-%s", platform.Name, time.Now().Format("20060102")),
+		Scopes: old.Scopes,
+		Expiry: old.Expiry,
+	})
+	if err != nil {
+		return nil, errors.New("create linode token: " + err.Error())
+	}
+
+	env := NewEnvObject(viper.GetString("pulumiOrg"), platform.Name, platform.Stack)
+	if _, ok := store.(*escStore); ok {
+		if shared, ok := sharedEnvFor(env.OrgName, "linode"); ok {
+			env = shared
+			logger.Warn(fmt.Sprintf("linode token is imported from %s/%s: all platforms importing it get the new token",
+				env.ProjName, env.EnvName))
+		}
+	}
+
+	env.Confirm = true
+	env.Items = map[int]EscEnvItem{
+		1: {Name: "linode", Value: map[string]any{"token": FnSecret(created.Token)}},
+	}
+
+	if err := store.Update(&env); err != nil {
+		// the new token is not stored anywhere, so it must not outlive the run
+		if err := client.DeleteToken(ctx, created.ID); err != nil {
+			logger.Error("revoke unused linode token: " + err.Error())
+		}
+
+		return nil, err
+	}
+
+	// cleanup funcs in this run authenticate with the environment token
+	if err := os.Setenv("LINODE_TOKEN", created.Token); err != nil {
+		logger.Error("set LINODE_TOKEN env: " + err.Error())
+	}
+
+	logger.Warn("update LINODE_TOKEN in your shell: the old token is being revoked")
+
+	verify := func() error {
+		newClient := newLinodeClient(created.Token)
+		if err := newClient.DeleteToken(ctx, old.ID); err != nil {
+			return errors.New("revoke old linode token: " + err.Error())
+		}
+
+		if _, err := client.GetProfile(ctx); err == nil {
+			return errors.New("old linode token is still accepted")
+		}
+
+		return nil
+	}
+
+	return verify, nil
+}
+
+// rotateObjKey replaces the infra stack's object storage key, which writes the
+// new key to the secret store and deletes the old one. The returned func checks
+// the old access key is gone.
+func rotateObjKey(ctx context.Context, store SecretStore, stk *MicroStack) (func() error, error) {
+	oldAccessKey, err := Get[string](store, "apl:objKey.accessKey")
+	if err != nil {
+		return nil, err
+	}
+
+	urn, err := stackResourceUrn(ctx, initLocalStack(ctx, stk), objKeyType)
+	if err != nil {
+		return nil, err
+	}
+
+	stk.Replace = []string{urn}
+	stk.Up(ctx)
+
+	verify := func() error {
+		client := NewLinodeClient()
+
+		keys, err := client.ListObjectStorageKeys(ctx, &linodego.ListOptions{})
+		if err != nil {
+			return errors.New("list object storage keys: " + err.Error())
+		}
+
+		for _, i := range keys {
+			if i.AccessKey == oldAccessKey {
+				return fmt.Errorf("old object storage key %d still exists", i.ID)
+			}
+		}
+
+		return nil
+	}
+
+	return verify, nil
+}
+
+// stackResourceUrn returns the urn of the first resource of a type in the
+// stack state.
+func stackResourceUrn(ctx context.Context, s auto.Stack, typ string) (string, error) {
+	var state struct {
+		Resources []struct {
+			URN  string `json:"urn"`
+			Type string `json:"type"`
+		} `json:"resources"`
+	}
+
+	dep, err := s.Export(ctx)
+	if err != nil {
+		return "", errors.New("export stack state: " + err.Error())
+	}
+
+	if err := json.Unmarshal(dep.Deployment, &state); err != nil {
+		return "", errors.New("json unmarshal stack state: " + err.Error())
+	}
+
+	for _, i := range state.Resources {
+		if i.Type == typ {
+			return i.URN, nil
+		}
+	}
+
+	return "", fmt.Errorf("no %s resource in %s stack", typ, s.Name())
+}
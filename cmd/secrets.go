@@ -0,0 +1,243 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/atotto/clipboard"
+	esc "github.com/pulumi/esc-sdk/sdk/go"
+	"github.com/spf13/cobra"
+)
+
+const (
+	initialCredsNamespace = "keycloak"
+	initialCredsSecret    = "platform-admin-initial-credentials"
+)
+
+var (
+	secretsCluster bool
+	secretsCopy    bool
+)
+
+var secretsCmd = &cobra.Command{
+	Use:   "secrets",
+	Short: "List and retrieve platform credentials",
+}
+
+var secretsListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List secret keys without their values",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx, cancel := context.WithTimeout(context.Background(), 1*time.Minute)
+		defer cancel()
+
+		var (
+			keys map[string]bool
+			err  error
+		)
+
+		if secretsCluster {
+			keys, err = clusterCredKeys(ctx)
+		} else {
+			keys, err = storeKeys(platformStore())
+		}
+
+		if err != nil {
+			logger.Error("list secrets: " + err.Error())
+
+			return
+		}
+
+		names := make([]string, 0, len(keys))
+		for k := range keys {
+			names = append(names, k)
+		}
+
+		sort.Strings(names)
+
+		logger.Info("header:secrets")
+
+		for _, i := range names {
+			line := i
+			if keys[i] {
+				line = fmt.Sprintf("%-40s %s[secret]%s", i, Yellow, Reset)
+			}
+
+			logger.Info("line:" + line)
+		}
+
+		fmt.Println() //nolint:forbidigo
+	},
+}
+
+var secretsGetCmd = &cobra.Command{
+	Use:   "get <key>",
+	Short: "Print or copy a secret value",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx, cancel := context.WithTimeout(context.Background(), 1*time.Minute)
+		defer cancel()
+
+		var (
+			value string
+			err   error
+		)
+
+		if secretsCluster {
+			value, err = clusterCred(ctx, args[0])
+		} else {
+			value, err = storeSecret(platformStore(), args[0])
+		}
+
+		if err != nil {
+			logger.Error("get secret: " + err.Error())
+
+			return
+		}
+
+		if !secretsCopy {
+			fmt.Println(value) //nolint:forbidigo
+
+			return
+		}
+
+		if err := clipboard.WriteAll(value); err != nil {
+			logger.Error("copy secret to clipboard: " + err.Error())
+
+			return
+		}
+
+		logger.Info(args[0] + " copied to clipboard")
+	},
+}
+
+func init() {
+	// required flags
+	secretsCmd.PersistentFlags().StringVarP(&platform.Name, "name", "n", "", "APL instance name (required)")
+	secretsCmd.MarkPersistentFlagRequired("name") //nolint:errcheck
+	// optional flags
+	secretsCmd.PersistentFlags().BoolVarP(&secretsCluster, "cluster", "", false,
+		"Use the "+initialCredsSecret+" secret of the cluster")
+	secretsGetCmd.Flags().BoolVarP(&secretsCopy, "copy", "", false, "Copy the value to the clipboard")
+
+	secretsCmd.AddCommand(secretsGetCmd, secretsListCmd)
+}
+
+// storeKeys returns the dotted key of every value in the secret store, and if
+// it is a secret. pulumiConfig entries are references and are left out.
+func storeKeys(store SecretStore) (map[string]bool, error) {
+	var values map[string]any
+
+	switch s := store.(type) {
+	case *escStore:
+		authCtx, escClient, err := esc.DefaultLogin()
+		if err != nil {
+			return nil, err
+		}
+
+		def, _, err := escClient.GetEnvironment(authCtx, s.org, s.proj, s.stack)
+		if err != nil {
+			return nil, err
+		}
+
+		values = def.GetValues().AdditionalProperties
+	case documentStore:
+		doc, err := s.document()
+		if err != nil {
+			return nil, err
+		}
+
+		values = doc.Values
+	}
+
+	leaves := make(map[string]string)
+	flattenEnv("", values, leaves)
+
+	keys := make(map[string]bool, len(leaves))
+	for k, v := range leaves {
+		keys[k] = strings.HasPrefix(v, "[secret")
+	}
+
+	return keys, nil
+}
+
+// storeSecret returns the plaintext value of a dotted key. A bare leaf name
+// such as otomiAdminPass is accepted if it is unique.
+func storeSecret(store SecretStore, key string) (string, error) {
+	keys, err := storeKeys(store)
+	if err != nil {
+		return "", err
+	}
+
+	if _, ok := keys[key]; !ok {
+		matches := make([]string, 0)
+
+		for k := range keys {
+			if strings.HasSuffix(k, "."+key) {
+				matches = append(matches, k)
+			}
+		}
+
+		switch len(matches) {
+		case 0:
+			return "", fmt.Errorf("%q not found", key)
+		case 1:
+			key = matches[0]
+		default:
+			sort.Strings(matches)
+
+			return "", fmt.Errorf("%q is ambiguous: %s", key, strings.Join(matches, ", "))
+		}
+	}
+
+	v, err := store.Get(key)
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprint(v), nil
+}
+
+func clusterCredKeys(ctx context.Context) (map[string]bool, error) {
+	data, err := initialCreds(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string]bool, len(data))
+	for k := range data {
+		keys[k] = true
+	}
+
+	return keys, nil
+}
+
+func clusterCred(ctx context.Context, key string) (string, error) {
+	data, err := initialCreds(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	v, ok := data[key]
+	if !ok {
+		return "", fmt.Errorf("%q not found in %s", key, initialCredsSecret)
+	}
+
+	return v, nil
+}
+
+// initialCreds reads the platform admin login created by the apl chart, using
+// the kubeconfig written by the infra stack.
+func initialCreds(ctx context.Context) (map[string]string, error) {
+	kc, err := newKubeClient(kubeconfigPath(platform.Name))
+	if err != nil {
+		return nil, errors.New("load kubeconfig: " + err.Error())
+	}
+
+	return kc.secretData(ctx, initialCredsNamespace, initialCredsSecret)
+}
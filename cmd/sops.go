@@ -0,0 +1,646 @@
+package cmd
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha512"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	age "filippo.io/age"
+	"filippo.io/age/armor"
+	"github.com/spf13/cobra"
+	yaml "gopkg.in/yaml.v3"
+)
+
+const (
+	sopsNonceSize         = 32
+	sopsUnencryptedSuffix = "_unencrypted"
+	sopsVersion           = "3.9.0"
+)
+
+var (
+	sopsInPlace bool
+	sopsValue   = regexp.MustCompile(`^ENC\[AES256_GCM,data:(.*),iv:(.+),tag:(.+),type:(.+)\]$`)
+)
+
+var sopsCmd = &cobra.Command{
+	Use:   "sops",
+	Short: "Encrypt and decrypt GitOps values with the platform age key",
+}
+
+var sopsRecipientsCmd = &cobra.Command{
+	Use:   "recipients",
+	Short: "Print the age public key of the platform",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		pub, err := Get[string](platformStore(), "apl:age.publicKey")
+		if err != nil {
+			logger.Error("get age public key: " + err.Error())
+
+			return
+		}
+
+		fmt.Println(pub) //nolint:forbidigo
+	},
+}
+
+var sopsEncryptCmd = &cobra.Command{
+	Use:   "encrypt <file>",
+	Short: "Encrypt a YAML file in the sops format",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		pub, err := Get[string](platformStore(), "apl:age.publicKey")
+		if err != nil {
+			logger.Error("get age public key: " + err.Error())
+
+			return
+		}
+
+		sopsFile(args[0], func(in []byte) ([]byte, error) {
+			return sopsEncrypt(in, pub)
+		})
+	},
+}
+
+var sopsDecryptCmd = &cobra.Command{
+	Use:   "decrypt <file>",
+	Short: "Decrypt a sops encrypted YAML file",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		key, err := Get[string](platformStore(), "apl:age.privateKey")
+		if err != nil {
+			logger.Error("get age private key: " + err.Error())
+
+			return
+		}
+
+		sopsFile(args[0], func(in []byte) ([]byte, error) {
+			return sopsDecrypt(in, key)
+		})
+	},
+}
+
+func init() {
+	// required flags
+	sopsCmd.PersistentFlags().StringVarP(&platform.Name, "name", "n", "", "APL instance name (required)")
+	sopsCmd.MarkPersistentFlagRequired("name") //nolint:errcheck
+	// optional flags
+	sopsCmd.PersistentFlags().BoolVarP(&sopsInPlace, "in-place", "i", false, "Write the output back to the file")
+
+	sopsCmd.AddCommand(sopsDecryptCmd, sopsEncryptCmd, sopsRecipientsCmd)
+}
+
+// sopsFile runs fn on the content of a file and prints the result, or
+// replaces the file with it when --in-place is set.
+func sopsFile(file string, fn func([]byte) ([]byte, error)) {
+	in, err := os.ReadFile(file)
+	if err != nil {
+		logger.Error("read " + file + ": " + err.Error())
+
+		return
+	}
+
+	out, err := fn(in)
+	if err != nil {
+		logger.Error("sops " + file + ": " + err.Error())
+
+		return
+	}
+
+	if !sopsInPlace {
+		fmt.Print(string(out)) //nolint:forbidigo
+
+		return
+	}
+
+	info, err := os.Stat(file)
+	if err != nil {
+		logger.Error("stat " + file + ": " + err.Error())
+
+		return
+	}
+
+	if err := os.WriteFile(file, out, info.Mode().Perm()); err != nil {
+		logger.Error("write " + file + ": " + err.Error())
+	}
+}
+
+// sopsEncrypt encrypts every value and comment of a YAML document the way
+// sops does: each scalar, empty strings included, with AES256-GCM under a
+// random data key, using its key path as additional data, and the data key
+// itself to the age recipient. A MAC over all values is stored encrypted in
+// the sops metadata.
+func sopsEncrypt(in []byte, recipient string) ([]byte, error) {
+	doc, err := sopsDocument(in)
+	if err != nil {
+		return nil, err
+	}
+
+	if sopsNode(doc, "sops") != nil {
+		return nil, errors.New("file is already encrypted")
+	}
+
+	r, err := age.ParseX25519Recipient(recipient)
+	if err != nil {
+		return nil, err
+	}
+
+	dataKey := make([]byte, 32)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, err
+	}
+
+	mac := sha512.New()
+
+	err = sopsWalk(doc, nil, func(n *yaml.Node, path []string) error {
+		typ, plain, macBytes, ok := sopsScalar(n)
+		if !ok {
+			return nil
+		}
+
+		mac.Write(macBytes)
+
+		if sopsUnencrypted(path, sopsUnencryptedSuffix) {
+			return nil
+		}
+
+		v, err := sopsSeal(dataKey, plain, typ, sopsPath(path))
+		if err != nil {
+			return err
+		}
+
+		n.Tag, n.Value, n.Style = "!!str", v, 0
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// comments are encrypted too, but are not part of the mac
+	err = sopsWalkComments(doc, nil, func(comment string, path []string) (string, error) {
+		if sopsUnencrypted(path, sopsUnencryptedSuffix) {
+			return comment, nil
+		}
+
+		return sopsSeal(dataKey, comment, "comment", sopsPath(path))
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	buf := &bytes.Buffer{}
+	aw := armor.NewWriter(buf)
+
+	w, err := age.Encrypt(aw, r)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := w.Write(dataKey); err != nil {
+		return nil, err
+	}
+
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	if err := aw.Close(); err != nil {
+		return nil, err
+	}
+
+	lastModified := time.Now().UTC().Format(time.RFC3339)
+
+	encMac, err := sopsSeal(dataKey, fmt.Sprintf("%X", mac.Sum(nil)), "str", lastModified)
+	if err != nil {
+		return nil, err
+	}
+
+	str := func(v string) *yaml.Node {
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: v}
+	}
+
+	stanza := &yaml.Node{Kind: yaml.MappingNode, Content: []*yaml.Node{
+		str("recipient"), str(recipient),
+		str("enc"), {Kind: yaml.ScalarNode, Tag: "!!str", Value: buf.String(), Style: yaml.LiteralStyle},
+	}}
+
+	none := func() *yaml.Node {
+		return &yaml.Node{Kind: yaml.SequenceNode, Style: yaml.FlowStyle}
+	}
+
+	// sops writes every key type, in this order, even when it is unused
+	meta := &yaml.Node{Kind: yaml.MappingNode, Content: []*yaml.Node{
+		str("kms"), none(),
+		str("gcp_kms"), none(),
+		str("azure_kv"), none(),
+		str("hc_vault"), none(),
+		str("age"), {Kind: yaml.SequenceNode, Content: []*yaml.Node{stanza}},
+		str("lastmodified"), {Kind: yaml.ScalarNode, Tag: "!!str", Value: lastModified, Style: yaml.DoubleQuotedStyle},
+		str("mac"), str(encMac),
+		str("pgp"), none(),
+		str("unencrypted_suffix"), str(sopsUnencryptedSuffix),
+		str("version"), str(sopsVersion),
+	}}
+
+	root := doc.Content[0]
+	root.Content = append(root.Content, str("sops"), meta)
+
+	return sopsMarshal(doc)
+}
+
+// sopsDecrypt decrypts a sops YAML document with an age identity and checks
+// its MAC. The sops metadata is removed from the output.
+func sopsDecrypt(in []byte, identity string) ([]byte, error) {
+	doc, err := sopsDocument(in)
+	if err != nil {
+		return nil, err
+	}
+
+	meta := sopsNode(doc, "sops")
+	if meta == nil {
+		return nil, errors.New("file is not sops encrypted")
+	}
+
+	id, err := age.ParseX25519Identity(strings.TrimSpace(identity))
+	if err != nil {
+		return nil, err
+	}
+
+	var dataKey []byte
+
+	if stanzas := sopsMapValue(meta, "age"); stanzas != nil {
+		for _, i := range stanzas.Content {
+			enc := sopsMapValue(i, "enc")
+			if enc == nil {
+				continue
+			}
+
+			r, err := age.Decrypt(armor.NewReader(strings.NewReader(enc.Value)), id)
+			if err != nil {
+				continue
+			}
+
+			if dataKey, err = io.ReadAll(r); err == nil {
+				break
+			}
+		}
+	}
+
+	if len(dataKey) == 0 {
+		return nil, errors.New("no age recipient of the file matches the platform age key")
+	}
+
+	suffix := sopsUnencryptedSuffix
+	if v := sopsMapValue(meta, "unencrypted_suffix"); v != nil {
+		suffix = v.Value
+	}
+
+	mac := sha512.New()
+
+	// remove the metadata before walking the values
+	root := doc.Content[0]
+	for i := 0; i < len(root.Content); i += 2 {
+		if root.Content[i].Value == "sops" {
+			root.Content = append(root.Content[:i], root.Content[i+2:]...)
+
+			break
+		}
+	}
+
+	err = sopsWalk(doc, nil, func(n *yaml.Node, path []string) error {
+		return sopsOpenNode(n, path, dataKey, suffix, mac)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = sopsWalkComments(doc, nil, func(comment string, path []string) (string, error) {
+		if sopsUnencrypted(path, suffix) || !sopsValue.MatchString(comment) {
+			return comment, nil
+		}
+
+		plain, _, err := sopsOpen(dataKey, comment, sopsPath(path))
+		if err != nil {
+			return "", fmt.Errorf("decrypt comment in %s: %s", sopsPath(path), err.Error())
+		}
+
+		return plain, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	lastModified := sopsMapValue(meta, "lastmodified")
+	encMac := sopsMapValue(meta, "mac")
+
+	if lastModified == nil || encMac == nil {
+		return nil, errors.New("sops metadata has no mac")
+	}
+
+	want, _, err := sopsOpen(dataKey, encMac.Value, lastModified.Value)
+	if err != nil {
+		return nil, errors.New("decrypt mac: " + err.Error())
+	}
+
+	if want != fmt.Sprintf("%X", mac.Sum(nil)) {
+		return nil, errors.New("mac mismatch: the file was modified outside of sops")
+	}
+
+	return sopsMarshal(doc)
+}
+
+// sopsOpenNode decrypts a scalar in place, restoring its yaml type, and adds
+// its plaintext to the mac.
+func sopsOpenNode(n *yaml.Node, path []string, dataKey []byte, suffix string, mac hash.Hash) error {
+	if n.Kind != yaml.ScalarNode || n.Tag == "!!null" {
+		return nil
+	}
+
+	if sopsUnencrypted(path, suffix) || !sopsValue.MatchString(n.Value) {
+		_, _, macBytes, ok := sopsScalar(n)
+		if ok {
+			mac.Write(macBytes)
+		}
+
+		return nil
+	}
+
+	plain, typ, err := sopsOpen(dataKey, n.Value, sopsPath(path))
+	if err != nil {
+		return fmt.Errorf("decrypt %s: %s", strings.Join(path, "."), err.Error())
+	}
+
+	n.Value, n.Style = plain, 0
+
+	switch typ {
+	case "int":
+		n.Tag = "!!int"
+	case "float":
+		n.Tag = "!!float"
+	case "bool":
+		n.Tag = "!!bool"
+	default:
+		n.Tag = "!!str"
+	}
+
+	_, _, macBytes, _ := sopsScalar(n)
+	mac.Write(macBytes)
+
+	return nil
+}
+
+// sopsScalar returns the sops type of a scalar, the plaintext that is
+// encrypted and the bytes that go into the mac, which differ for booleans.
+func sopsScalar(n *yaml.Node) (string, string, []byte, bool) {
+	if n.Kind != yaml.ScalarNode {
+		return "", "", nil, false
+	}
+
+	switch n.ShortTag() {
+	case "!!null":
+		return "", "", nil, false
+	case "!!int":
+		if i, err := strconv.ParseInt(n.Value, 0, 64); err == nil {
+			s := strconv.FormatInt(i, 10)
+
+			return "int", s, []byte(s), true
+		}
+	case "!!float":
+		if f, err := strconv.ParseFloat(n.Value, 64); err == nil {
+			s := strconv.FormatFloat(f, 'f', -1, 64)
+
+			return "float", s, []byte(s), true
+		}
+	case "!!bool":
+		var b bool
+		if err := n.Decode(&b); err == nil {
+			macBytes := []byte("False")
+			if b {
+				macBytes = []byte("True")
+			}
+
+			return "bool", strconv.FormatBool(b), macBytes, true
+		}
+	}
+
+	return "str", n.Value, []byte(n.Value), true
+}
+
+// sopsSeal encrypts a value into the ENC[AES256_GCM,...] form of sops.
+func sopsSeal(key []byte, plain, typ, additionalData string) (string, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return "", err
+	}
+
+	gcm, err := cipher.NewGCMWithNonceSize(block, sopsNonceSize)
+	if err != nil {
+		return "", err
+	}
+
+	iv := make([]byte, sopsNonceSize)
+	if _, err := rand.Read(iv); err != nil {
+		return "", err
+	}
+
+	out := gcm.Seal(nil, iv, []byte(plain), []byte(additionalData))
+	tag := len(out) - aes.BlockSize
+	enc := base64.StdEncoding.EncodeToString
+
+	return fmt.Sprintf("ENC[AES256_GCM,data:%s,iv:%s,tag:%s,type:%s]", enc(out[:tag]), enc(iv), enc(out[tag:]), typ), nil
+}
+
+// sopsOpen decrypts an ENC[AES256_GCM,...] value and returns it with its type.
+func sopsOpen(key []byte, value, additionalData string) (string, string, error) {
+	m := sopsValue.FindStringSubmatch(value)
+	if m == nil {
+		return "", "", errors.New("value is not in the sops format")
+	}
+
+	parts := make([][]byte, 0, 3)
+
+	for _, i := range m[1:4] {
+		b, err := base64.StdEncoding.DecodeString(i)
+		if err != nil {
+			return "", "", err
+		}
+
+		parts = append(parts, b)
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return "", "", err
+	}
+
+	gcm, err := cipher.NewGCMWithNonceSize(block, len(parts[1]))
+	if err != nil {
+		return "", "", err
+	}
+
+	plain, err := gcm.Open(nil, parts[1], append(parts[0], parts[2]...), []byte(additionalData))
+	if err != nil {
+		return "", "", err
+	}
+
+	return string(plain), m[4], nil
+}
+
+// sopsWalk calls fn for every scalar with the path of mapping keys leading to
+// it. Like sops, sequence items share the path of the sequence.
+func sopsWalk(n *yaml.Node, path []string, fn func(*yaml.Node, []string) error) error {
+	switch n.Kind {
+	case yaml.DocumentNode, yaml.SequenceNode:
+		for _, i := range n.Content {
+			if err := sopsWalk(i, path, fn); err != nil {
+				return err
+			}
+		}
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			p := append(append([]string{}, path...), n.Content[i].Value)
+			if err := sopsWalk(n.Content[i+1], p, fn); err != nil {
+				return err
+			}
+		}
+	case yaml.ScalarNode:
+		return fn(n, path)
+	case yaml.AliasNode:
+		return errors.New("yaml aliases are not supported")
+	}
+
+	return nil
+}
+
+// sopsWalkComments calls fn for every comment line, without its leading #,
+// with the path of the mapping or sequence holding it, and replaces the line
+// with the result. Like sops, comments are written as #ENC[...] when encrypted.
+func sopsWalkComments(n *yaml.Node, path []string, fn func(string, []string) (string, error)) error {
+	for _, c := range []*string{&n.HeadComment, &n.LineComment, &n.FootComment} {
+		if *c == "" {
+			continue
+		}
+
+		lines := strings.Split(*c, "\n")
+		for idx, i := range lines {
+			if !strings.HasPrefix(i, "#") {
+				continue
+			}
+
+			v, err := fn(i[1:], path)
+			if err != nil {
+				return err
+			}
+
+			lines[idx] = "#" + v
+		}
+
+		*c = strings.Join(lines, "\n")
+	}
+
+	switch n.Kind {
+	case yaml.DocumentNode, yaml.SequenceNode:
+		for _, i := range n.Content {
+			if err := sopsWalkComments(i, path, fn); err != nil {
+				return err
+			}
+		}
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			if err := sopsWalkComments(n.Content[i], path, fn); err != nil {
+				return err
+			}
+
+			// comments of a scalar value belong to the mapping holding it
+			p := path
+			if n.Content[i+1].Kind != yaml.ScalarNode {
+				p = append(append([]string{}, path...), n.Content[i].Value)
+			}
+
+			if err := sopsWalkComments(n.Content[i+1], p, fn); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func sopsDocument(in []byte) (*yaml.Node, error) {
+	var doc yaml.Node
+
+	if err := yaml.Unmarshal(in, &doc); err != nil {
+		return nil, errors.New("yaml unmarshal: " + err.Error())
+	}
+
+	if doc.Kind != yaml.DocumentNode || len(doc.Content) == 0 || doc.Content[0].Kind != yaml.MappingNode {
+		return nil, errors.New("yaml document must be a mapping")
+	}
+
+	return &doc, nil
+}
+
+func sopsMarshal(doc *yaml.Node) ([]byte, error) {
+	buf := &bytes.Buffer{}
+
+	// sops writes yaml with an indent of 4
+	enc := yaml.NewEncoder(buf)
+	enc.SetIndent(4)
+
+	if err := enc.Encode(doc); err != nil {
+		return nil, err
+	}
+
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// sopsNode returns the value of a top level key of a document.
+func sopsNode(doc *yaml.Node, key string) *yaml.Node {
+	return sopsMapValue(doc.Content[0], key)
+}
+
+func sopsMapValue(n *yaml.Node, key string) *yaml.Node {
+	if n.Kind != yaml.MappingNode {
+		return nil
+	}
+
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		if n.Content[i].Value == key {
+			return n.Content[i+1]
+		}
+	}
+
+	return nil
+}
+
+func sopsUnencrypted(path []string, suffix string) bool {
+	for _, i := range path {
+		if strings.HasSuffix(i, suffix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// sopsPath is the additional data of a value: its key path, each key followed
+// by a colon. Comments at the top level get a single colon.
+func sopsPath(path []string) string {
+	return strings.Join(path, ":") + ":"
+}
@@ -0,0 +1,226 @@
+package cmd
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	age "filippo.io/age"
+	yaml "gopkg.in/yaml.v3"
+)
+
+const sopsTestDoc = `# database settings
+db:
+    # admin login
+    user: admin
+    password: s3cr3t
+    empty: ""
+    port: 5432
+    ratio: 0.5
+    enabled: true
+    hosts:
+        - a.example.com
+        - b.example.com
+    nothing: null
+public_unencrypted: visible
+`
+
+func sopsTestKey(t *testing.T) *age.X25519Identity {
+	t.Helper()
+
+	id, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return id
+}
+
+func sopsTestValues(t *testing.T, data []byte) map[string]any {
+	t.Helper()
+
+	var v map[string]any
+	if err := yaml.Unmarshal(data, &v); err != nil {
+		t.Fatalf("yaml unmarshal: %v", err)
+	}
+
+	delete(v, "sops")
+
+	return v
+}
+
+func TestSopsRoundTrip(t *testing.T) {
+	id := sopsTestKey(t)
+
+	enc, err := sopsEncrypt([]byte(sopsTestDoc), id.Recipient().String())
+	if err != nil {
+		t.Fatalf("encrypt: %v", err)
+	}
+
+	for _, i := range []string{"admin", "s3cr3t", "5432", "a.example.com", "database settings", "admin login"} {
+		if strings.Contains(string(enc), i) {
+			t.Errorf("encrypted document contains plaintext %q", i)
+		}
+	}
+
+	if !strings.Contains(string(enc), "public_unencrypted: visible") {
+		t.Error("unencrypted suffix value was encrypted")
+	}
+
+	if !strings.Contains(string(enc), "#ENC[AES256_GCM,") || !strings.Contains(string(enc), "type:comment]") {
+		t.Error("comments are not written as #ENC[...]")
+	}
+
+	db, _ := sopsTestValues(t, enc)["db"].(map[string]any)
+	if v, _ := db["empty"].(string); !strings.HasPrefix(v, "ENC[AES256_GCM,data:,") {
+		t.Errorf("empty string is not encrypted: %q", v)
+	}
+
+	dec, err := sopsDecrypt(enc, id.String())
+	if err != nil {
+		t.Fatalf("decrypt: %v", err)
+	}
+
+	if got, want := sopsTestValues(t, dec), sopsTestValues(t, []byte(sopsTestDoc)); !reflect.DeepEqual(got, want) {
+		t.Errorf("round trip changed values\ngot:  %v\nwant: %v", got, want)
+	}
+
+	for _, i := range []string{"# database settings", "# admin login"} {
+		if !strings.Contains(string(dec), i) {
+			t.Errorf("decrypted document is missing comment %q", i)
+		}
+	}
+
+	if _, err := sopsDecrypt(enc, sopsTestKey(t).String()); err == nil {
+		t.Error("decrypt with another age key succeeded")
+	}
+
+	tampered := strings.Replace(string(enc), "public_unencrypted: visible", "public_unencrypted: changed", 1)
+	if _, err := sopsDecrypt([]byte(tampered), id.String()); err == nil {
+		t.Error("decrypt of a tampered document succeeded")
+	}
+}
+
+// TestSopsFixture decrypts a checked in document in the layout sops 3.9
+// writes, so the format is checked without the sops binary. To refresh it,
+// encrypt sopsTestDoc with `sops --encrypt` to the key in testdata/sops.agekey.
+func TestSopsFixture(t *testing.T) {
+	key, err := os.ReadFile(filepath.Join("testdata", "sops.agekey"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	enc, err := os.ReadFile(filepath.Join("testdata", "sops.enc.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dec, err := sopsDecrypt(enc, string(key))
+	if err != nil {
+		t.Fatalf("decrypt: %v", err)
+	}
+
+	if got, want := sopsTestValues(t, dec), sopsTestValues(t, []byte(sopsTestDoc)); !reflect.DeepEqual(got, want) {
+		t.Errorf("decrypt fixture\ngot:  %v\nwant: %v", got, want)
+	}
+}
+
+// TestSopsMetadata checks that the sops metadata has every key sops writes,
+// in its order.
+func TestSopsMetadata(t *testing.T) {
+	enc, err := sopsEncrypt([]byte(sopsTestDoc), sopsTestKey(t).Recipient().String())
+	if err != nil {
+		t.Fatalf("encrypt: %v", err)
+	}
+
+	doc, err := sopsDocument(enc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	meta := sopsNode(doc, "sops")
+	if meta == nil {
+		t.Fatal("no sops metadata")
+	}
+
+	var keys []string
+	for i := 0; i < len(meta.Content); i += 2 {
+		keys = append(keys, meta.Content[i].Value)
+	}
+
+	want := []string{"kms", "gcp_kms", "azure_kv", "hc_vault", "age", "lastmodified", "mac", "pgp", "unencrypted_suffix", "version"}
+	if !reflect.DeepEqual(keys, want) {
+		t.Errorf("sops metadata keys\ngot:  %v\nwant: %v", keys, want)
+	}
+}
+
+// TestSopsBinary checks both directions against the sops binary, which is
+// used when it is on the PATH.
+func TestSopsBinary(t *testing.T) {
+	bin, err := exec.LookPath("sops")
+	if err != nil {
+		t.Skip("sops binary not found")
+	}
+
+	id := sopsTestKey(t)
+	dir := t.TempDir()
+	want := sopsTestValues(t, []byte(sopsTestDoc))
+
+	run := func(env string, args ...string) []byte {
+		t.Helper()
+
+		cmd := exec.Command(bin, args...)
+		cmd.Env = append(os.Environ(), env)
+
+		out, err := cmd.Output()
+		if err != nil {
+			t.Fatalf("sops %s: %v", strings.Join(args, " "), err)
+		}
+
+		return out
+	}
+
+	plain := filepath.Join(dir, "plain.yaml")
+	if err := os.WriteFile(plain, []byte(sopsTestDoc), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	enc := run("SOPS_AGE_RECIPIENTS="+id.Recipient().String(), "--encrypt", plain)
+
+	dec, err := sopsDecrypt(enc, id.String())
+	if err != nil {
+		t.Fatalf("decrypt sops output: %v", err)
+	}
+
+	if got := sopsTestValues(t, dec); !reflect.DeepEqual(got, want) {
+		t.Errorf("decrypt sops output\ngot:  %v\nwant: %v", got, want)
+	}
+
+	ours, err := sopsEncrypt([]byte(sopsTestDoc), id.Recipient().String())
+	if err != nil {
+		t.Fatalf("encrypt: %v", err)
+	}
+
+	encFile := filepath.Join(dir, "enc.yaml")
+	if err := os.WriteFile(encFile, ours, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	out := run("SOPS_AGE_KEY="+id.String(), "--decrypt", encFile)
+	if got := sopsTestValues(t, out); !reflect.DeepEqual(got, want) {
+		t.Errorf("sops decrypt of our output\ngot:  %v\nwant: %v", got, want)
+	}
+
+	key, err := os.ReadFile(filepath.Join("testdata", "sops.agekey"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out = run("SOPS_AGE_KEY="+strings.TrimSpace(string(key)), "--decrypt", filepath.Join("testdata", "sops.enc.yaml"))
+	if got := sopsTestValues(t, out); !reflect.DeepEqual(got, want) {
+		t.Errorf("sops decrypt of the fixture\ngot:  %v\nwant: %v", got, want)
+	}
+}
@@ -0,0 +1,791 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	age "filippo.io/age"
+	esc "github.com/pulumi/esc-sdk/sdk/go"
+	"github.com/pulumi/pulumi/sdk/v3/go/auto"
+	"github.com/spf13/viper"
+)
+
+var (
+	// ErrKeyNotFound is returned when a key is not in the secret store.
+	ErrKeyNotFound = errors.New("key not found")
+	// ErrKeyType is returned when a value cannot be converted to the
+	// requested type.
+	ErrKeyType = errors.New("value type mismatch")
+
+	errStoreNotFound = errors.New("secret store not found")
+	errVaultCas      = errors.New("vault: check-and-set version mismatch")
+	storeRef         = regexp.MustCompile(`\$\{([^}]+)\}`)
+)
+
+// ageKeyFileEnv names the age identity file of the file store, the way
+// SOPS_AGE_KEY_FILE does for sops.
+const ageKeyFileEnv = "APLCLI_AGE_KEY_FILE"
+
+// SecretStore is the backend holding the pulumiConfig and values of a platform
+// environment. Pulumi ESC is the default, while the file and vault stores let
+// aplcli run without Pulumi Cloud. The backend is selected per platform with
+// the secretStore config key.
+type SecretStore interface {
+	Init(env *EscEnv) error
+	Exists() bool
+	Get(key string) (any, error)
+	Update(env *EscEnv) error
+	Remove() error
+}
+
+// documentStore is implemented by stores that keep the whole environment as
+// one document. Their pulumiConfig is copied to stack config before a stack
+// runs, since stacks can only import ESC environments.
+type documentStore interface {
+	document() (storeDoc, error)
+}
+
+// storeDoc mirrors the layout of an ESC environment definition.
+type storeDoc struct {
+	PulumiConfig map[string]any `json:"pulumiConfig"`
+	Values       map[string]any `json:"values"`
+}
+
+type escStore struct {
+	org   string
+	proj  string
+	stack string
+}
+
+type fileStore struct {
+	keyFile string
+	path    string
+}
+
+type vaultStore struct {
+	addr  string
+	mount string
+	path  string
+	token string
+}
+
+func NewSecretStore(org, proj, stack string) SecretStore {
+	switch platform.SecretStore {
+	case "file":
+		dir := filepath.Join(paths.Config, "secrets")
+
+		return &fileStore{
+			keyFile: fileStoreKey(platform.AgeKeyFile, proj, stack),
+			path:    filepath.Join(dir, fmt.Sprintf("%s-%s.json.age", proj, stack)),
+		}
+	case "vault":
+		addr := platform.VaultAddr
+		if addr == "" {
+			addr = os.Getenv("VAULT_ADDR")
+		}
+
+		mount := platform.VaultMount
+		if mount == "" {
+			mount = "secret"
+		}
+
+		return &vaultStore{
+			addr:  strings.TrimSuffix(addr, "/"),
+			mount: mount,
+			path:  fmt.Sprintf("aplcli/%s/%s/%s", org, proj, stack),
+			token: os.Getenv("VAULT_TOKEN"),
+		}
+	default:
+		return &escStore{org: org, proj: proj, stack: stack}
+	}
+}
+
+// fileStoreKey returns the age identity file of the file store: the
+// APLCLI_AGE_KEY_FILE env var, ageKeyFile of the platform config, or a file
+// in the age config dir of the user. It is never kept in the store dir, so a
+// copy of the store does not carry the key that decrypts it.
+func fileStoreKey(file, proj, stack string) string {
+	if v := os.Getenv(ageKeyFileEnv); v != "" {
+		return v
+	}
+
+	if file != "" {
+		return file
+	}
+
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		logger.Error("locate user config directory: " + err.Error())
+	}
+
+	return filepath.Join(dir, "age", fmt.Sprintf("aplcli-%s-%s.key", proj, stack))
+}
+
+// Get returns the value of a key in the secret store as type T. The key is a
+// pulumiConfig key such as lkeId, or a dotted path such as objKey.accessKey.
+// Numbers arrive as float64 from json and are converted to the integer types.
+// A missing key wraps ErrKeyNotFound and a value of another type ErrKeyType.
+func Get[T any](store SecretStore, key string) (T, error) {
+	var out T
+
+	v, err := store.Get(key)
+	if err != nil {
+		return out, err
+	}
+
+	if t, ok := v.(T); ok {
+		return t, nil
+	}
+
+	// convert through json, which also rejects fractional numbers for ints
+	data, err := json.Marshal(v)
+	if err != nil {
+		return out, fmt.Errorf("%w: %q: %s", ErrKeyType, key, err.Error())
+	}
+
+	if err := json.Unmarshal(data, &out); err != nil {
+		return out, fmt.Errorf("%w: %q is %T, wants %T", ErrKeyType, key, v, out)
+	}
+
+	return out, nil
+}
+
+// lookupKey finds a pulumiConfig key, then a dotted path starting at a
+// pulumiConfig key, then a dotted path in values. The found value is passed
+// through resolve.
+func lookupKey(config, values map[string]any, key string, resolve func(any) (any, error)) (any, error) {
+	if v, ok := config[key]; ok {
+		return resolve(v)
+	}
+
+	if head, rest, ok := strings.Cut(key, "."); ok {
+		if v, ok := config[head]; ok {
+			r, err := resolve(v)
+			if err != nil {
+				return nil, err
+			}
+
+			return walkPath(r, rest, key)
+		}
+	}
+
+	v, err := walkPath(values, key, key)
+	if err != nil {
+		return nil, err
+	}
+
+	return resolve(v)
+}
+
+func walkPath(v any, path, key string) (any, error) {
+	cur := v
+
+	for _, i := range strings.Split(path, ".") {
+		m, ok := cur.(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("%w: %q", ErrKeyNotFound, key)
+		}
+
+		if cur, ok = m[i]; !ok {
+			return nil, fmt.Errorf("%w: %q", ErrKeyNotFound, key)
+		}
+	}
+
+	return cur, nil
+}
+
+// esc store
+
+func (s *escStore) Init(env *EscEnv) error {
+	env.Init()
+
+	return nil
+}
+
+func (s *escStore) Exists() bool {
+	return EscExists(s.org, s.proj, s.stack)
+}
+
+func (s *escStore) Get(key string) (any, error) {
+	authCtx, escClient, err := esc.DefaultLogin()
+	if err != nil {
+		return nil, err
+	}
+
+	_, values, err := escClient.OpenAndReadEnvironment(authCtx, s.org, s.proj, s.stack)
+	if err != nil {
+		return nil, err
+	}
+
+	// values are resolved by esc when the environment is opened
+	config, _ := values["pulumiConfig"].(map[string]any)
+
+	return lookupKey(config, values, key, func(v any) (any, error) {
+		return v, nil
+	})
+}
+
+func (s *escStore) Update(env *EscEnv) error {
+	return env.Update()
+}
+
+func (s *escStore) Remove() error {
+	env := NewEnvObject(s.org, s.proj, s.stack)
+	env.Remove()
+
+	return nil
+}
+
+// file store: a json document encrypted to an age identity kept outside the
+// store dir, see fileStoreKey
+
+func (s *fileStore) Init(env *EscEnv) error {
+	if s.Exists() {
+		logger.Info("secret store already initialized")
+
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(s.path), 0700); err != nil {
+		return err
+	}
+
+	if _, err := os.Stat(s.keyFile); err != nil {
+		if err := os.MkdirAll(filepath.Dir(s.keyFile), 0700); err != nil {
+			return err
+		}
+
+		ageKeys, err := GenAgeKeys()
+		if err != nil {
+			return err
+		}
+
+		if err := os.WriteFile(s.keyFile, []byte(ageKeys.String()+"\n"), 0600); err != nil {
+			return err
+		}
+
+		logger.Info("age key for secret store written to: " + s.keyFile)
+	}
+
+	doc := storeDoc{}
+	doc.merge(env)
+
+	return s.write(doc)
+}
+
+func (s *fileStore) Exists() bool {
+	_, err := os.Stat(s.path)
+
+	return err == nil
+}
+
+func (s *fileStore) Get(key string) (any, error) {
+	doc, err := s.document()
+	if err != nil {
+		return nil, err
+	}
+
+	return doc.get(key)
+}
+
+func (s *fileStore) Update(env *EscEnv) error {
+	escMu.Lock()
+	defer escMu.Unlock()
+
+	doc, err := s.document()
+	if err != nil {
+		return err
+	}
+
+	doc.merge(env)
+
+	return s.write(doc)
+}
+
+// Remove deletes the store. The age key is kept, since it may be shared or
+// managed outside of aplcli.
+func (s *fileStore) Remove() error {
+	if err := os.Remove(s.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	logger.Info("purged secret store, age key kept in: " + s.keyFile)
+
+	return nil
+}
+
+func (s *fileStore) identity() (*age.X25519Identity, error) {
+	if err := s.moveLegacyKey(); err != nil {
+		return nil, errors.New("move age key out of the store dir: " + err.Error())
+	}
+
+	key, err := os.ReadFile(s.keyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	return age.ParseX25519Identity(strings.TrimSpace(string(key)))
+}
+
+// moveLegacyKey moves the age key of a store created when the key was kept
+// next to it to the key file.
+func (s *fileStore) moveLegacyKey() error {
+	legacy := strings.TrimSuffix(s.path, ".json.age") + ".key"
+	if legacy == s.keyFile {
+		return nil
+	}
+
+	if _, err := os.Stat(s.keyFile); err == nil {
+		return nil
+	}
+
+	key, err := os.ReadFile(legacy)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(s.keyFile), 0700); err != nil {
+		return err
+	}
+
+	if err := os.WriteFile(s.keyFile, key, 0600); err != nil {
+		return err
+	}
+
+	logger.Warn("age key for secret store moved to: " + s.keyFile)
+
+	return os.Remove(legacy)
+}
+
+func (s *fileStore) document() (storeDoc, error) {
+	var doc storeDoc
+
+	id, err := s.identity()
+	if err != nil {
+		return doc, err
+	}
+
+	f, err := os.Open(s.path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return doc, errStoreNotFound
+		}
+
+		return doc, err
+	}
+
+	defer f.Close()
+
+	r, err := age.Decrypt(f, id)
+	if err != nil {
+		return doc, err
+	}
+
+	err = json.NewDecoder(r).Decode(&doc)
+
+	return doc, err
+}
+
+// write encrypts the document to a temporary file and renames it over the
+// store, so a failed write never leaves a truncated store behind.
+func (s *fileStore) write(doc storeDoc) error {
+	id, err := s.identity()
+	if err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(doc)
+	if err != nil {
+		return err
+	}
+
+	buf := &bytes.Buffer{}
+
+	w, err := age.Encrypt(buf, id.Recipient())
+	if err != nil {
+		return err
+	}
+
+	if _, err := w.Write(data); err != nil {
+		return err
+	}
+
+	if err := w.Close(); err != nil {
+		return err
+	}
+
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, buf.Bytes(), 0600); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, s.path)
+}
+
+// vault store: a kv v2 secret, written with check-and-set
+
+func (s *vaultStore) Init(env *EscEnv) error {
+	if s.Exists() {
+		logger.Info("secret store already initialized")
+
+		return nil
+	}
+
+	doc := storeDoc{}
+	doc.merge(env)
+
+	return s.write(doc, 0)
+}
+
+func (s *vaultStore) Exists() bool {
+	_, _, err := s.read()
+
+	return err == nil
+}
+
+func (s *vaultStore) Get(key string) (any, error) {
+	doc, err := s.document()
+	if err != nil {
+		return nil, err
+	}
+
+	return doc.get(key)
+}
+
+func (s *vaultStore) Update(env *EscEnv) error {
+	escMu.Lock()
+	defer escMu.Unlock()
+
+	for attempt := 1; attempt <= escUpdateRetries; attempt++ {
+		doc, version, err := s.read()
+		if err != nil {
+			return err
+		}
+
+		doc.merge(env)
+
+		err = s.write(doc, version)
+		if !errors.Is(err, errVaultCas) {
+			return err
+		}
+
+		msg := fmt.Sprintf("vault secret changed during update: retrying (%d/%d)", attempt, escUpdateRetries)
+		logger.Warn(msg)
+
+		time.Sleep(time.Duration(attempt) * time.Second)
+	}
+
+	return errors.New("too many conflicting writes")
+}
+
+func (s *vaultStore) Remove() error {
+	res, err := s.request(http.MethodDelete, "metadata", nil)
+	if err != nil {
+		return err
+	}
+
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusNoContent && res.StatusCode != http.StatusOK {
+		return errors.New("vault: " + res.Status)
+	}
+
+	logger.Info("purged secret store")
+
+	return nil
+}
+
+func (s *vaultStore) document() (storeDoc, error) {
+	doc, _, err := s.read()
+
+	return doc, err
+}
+
+// read returns the document and its version, used for check-and-set writes.
+func (s *vaultStore) read() (storeDoc, int, error) {
+	var secret struct {
+		Data struct {
+			Data     storeDoc `json:"data"`
+			Metadata struct {
+				Version int `json:"version"`
+			} `json:"metadata"`
+		} `json:"data"`
+	}
+
+	res, err := s.request(http.MethodGet, "data", nil)
+	if err != nil {
+		return storeDoc{}, 0, err
+	}
+
+	defer res.Body.Close()
+
+	switch res.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return storeDoc{}, 0, errStoreNotFound
+	default:
+		return storeDoc{}, 0, errors.New("vault: " + res.Status)
+	}
+
+	if err := json.NewDecoder(res.Body).Decode(&secret); err != nil {
+		return storeDoc{}, 0, err
+	}
+
+	return secret.Data.Data, secret.Data.Metadata.Version, nil
+}
+
+func (s *vaultStore) write(doc storeDoc, version int) error {
+	body := map[string]any{
+		"options": map[string]int{"cas": version},
+		"data":    doc,
+	}
+
+	res, err := s.request(http.MethodPost, "data", body)
+	if err != nil {
+		return err
+	}
+
+	defer res.Body.Close()
+
+	switch res.StatusCode {
+	case http.StatusOK, http.StatusNoContent:
+		return nil
+	case http.StatusBadRequest:
+		msg, _ := io.ReadAll(res.Body)
+		if strings.Contains(string(msg), "check-and-set") {
+			return errVaultCas
+		}
+
+		return errors.New("vault: " + strings.TrimSpace(string(msg)))
+	default:
+		return errors.New("vault: " + res.Status)
+	}
+}
+
+func (s *vaultStore) request(method, kind string, body any) (*http.Response, error) {
+	if s.addr == "" || s.token == "" {
+		return nil, errors.New("vault: vaultAddr (or VAULT_ADDR) and VAULT_TOKEN are required")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	var r io.Reader
+
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+
+		r = bytes.NewReader(data)
+	}
+
+	url := fmt.Sprintf("%s/v1/%s/%s/%s", s.addr, s.mount, kind, s.path)
+
+	req, err := http.NewRequestWithContext(ctx, method, url, r)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Add("Content-Type", "application/json")
+	req.Header.Add("X-Vault-Token", s.token)
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	// buffer the body so it outlives the request context
+	data, err := io.ReadAll(res.Body)
+	res.Body.Close()
+
+	if err != nil {
+		return nil, err
+	}
+
+	res.Body = io.NopCloser(bytes.NewReader(data))
+
+	return res, nil
+}
+
+// document helpers
+
+// merge applies the config and values of an EscEnv to the document, the same
+// way EscEnv.Update merges them into an ESC environment.
+func (d *storeDoc) merge(env *EscEnv) {
+	if d.PulumiConfig == nil {
+		d.PulumiConfig = make(map[string]any)
+	}
+
+	if d.Values == nil {
+		d.Values = make(map[string]any)
+	}
+
+	maps.Copy(d.PulumiConfig, env.Config)
+
+	switch {
+	case len(env.Objects) > 0:
+		for _, i := range env.Objects {
+			d.Values[i.Name] = i.Value
+		}
+	case len(env.Items) > 0:
+		d.Values = env.BuildValues(d.Values)
+	}
+}
+
+// get looks up a key like lookupKey, resolving references and secrets of the
+// value found.
+func (d *storeDoc) get(key string) (any, error) {
+	secret := false
+
+	return lookupKey(d.PulumiConfig, d.Values, key, func(v any) (any, error) {
+		return d.resolveValue(v, &secret)
+	})
+}
+
+// resolve returns the pulumiConfig value of key with ${path} references to
+// values interpolated and fn::secret wrappers removed. The bool reports if any
+// part of the value is a secret.
+func (d *storeDoc) resolve(key string) (any, bool, error) {
+	v, ok := d.PulumiConfig[key]
+	if !ok {
+		return nil, false, fmt.Errorf("%w: %q", ErrKeyNotFound, key)
+	}
+
+	secret := false
+	res, err := d.resolveValue(v, &secret)
+
+	return res, secret, err
+}
+
+func (d *storeDoc) resolveValue(v any, secret *bool) (any, error) {
+	switch val := v.(type) {
+	case string:
+		// a value that is a single reference keeps the referenced type
+		if m := storeRef.FindStringSubmatch(val); m != nil && m[0] == val {
+			ref, err := d.lookup(m[1])
+			if err != nil {
+				return nil, err
+			}
+
+			return d.resolveValue(ref, secret)
+		}
+
+		var err error
+
+		res := storeRef.ReplaceAllStringFunc(val, func(i string) string {
+			ref, e := d.lookup(storeRef.FindStringSubmatch(i)[1])
+			if e != nil {
+				err = e
+
+				return i
+			}
+
+			r, e := d.resolveValue(ref, secret)
+			if e != nil {
+				err = e
+			}
+
+			return fmt.Sprint(r)
+		})
+
+		return res, err
+	case map[string]any:
+		if s, ok := val["fn::secret"]; ok && len(val) == 1 {
+			*secret = true
+
+			return d.resolveValue(s, secret)
+		}
+
+		res := make(map[string]any, len(val))
+
+		for k, i := range val {
+			r, err := d.resolveValue(i, secret)
+			if err != nil {
+				return nil, err
+			}
+
+			res[k] = r
+		}
+
+		return res, nil
+	default:
+		return val, nil
+	}
+}
+
+// lookup walks a dotted path through the document values.
+func (d *storeDoc) lookup(path string) (any, error) {
+	var cur any = d.Values
+
+	for _, i := range strings.Split(path, ".") {
+		m, ok := cur.(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("reference ${%s} not found", path)
+		}
+
+		if cur, ok = m[i]; !ok {
+			return nil, fmt.Errorf("reference ${%s} not found", path)
+		}
+	}
+
+	return cur, nil
+}
+
+// syncStoreConfig copies the resolved pulumiConfig of a document store to the
+// stack config, as secrets where the store value is a secret.
+func syncStoreConfig(ctx context.Context, s auto.Stack, ds documentStore) {
+	doc, err := ds.document()
+	if err != nil {
+		logger.Error("read secret store for stack config: " + err.Error())
+
+		return
+	}
+
+	for key := range doc.PulumiConfig {
+		v, secret, err := doc.resolve(key)
+		if err != nil {
+			logger.Error("resolve " + key + " from secret store: " + err.Error())
+
+			continue
+		}
+
+		value, ok := v.(string)
+		if !ok {
+			data, err := json.Marshal(v)
+			if err != nil {
+				logger.Error("json marshal " + key + " from secret store: " + err.Error())
+
+				continue
+			}
+
+			value = string(data)
+		}
+
+		if err := s.SetConfig(ctx, key, auto.ConfigValue{Value: value, Secret: secret}); err != nil {
+			msg := fmt.Sprintf("failed to set %s in %s stack config: %s", key, s.Name(), err.Error())
+			logger.Error(msg)
+		}
+	}
+}
+
+// platformStore returns the secret store of the running platform.
+func platformStore() SecretStore {
+	return NewSecretStore(viper.GetString("pulumiOrg"), platform.Name, platform.Stack)
+}
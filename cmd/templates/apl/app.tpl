@@ -133,6 +133,13 @@ func run(ctx *pulumi.Context, r *AplResourceInfo) error {
 		return err
 	}
 
+	// helm: a version pinned in stack config by `aplcli upgrade` wins over the
+	// version the project was generated with
+	chartVersion := aplVersion
+	if v, ok := ctx.GetConfig(label + ":aplVersion"); ok && v != "" {
+		chartVersion = v
+	}
+
 	// helm: deploy apl chart
 	values := utils.YamlTemplate(ctx, "./helm/values.tpl", override)
 	aplChart := HelmOptions{
@@ -144,13 +151,13 @@ func run(ctx *pulumi.Context, r *AplResourceInfo) error {
 		ReuseValues:     true,
 		Timeout:         1200,
 		ValuesFile:      values,
-		Version:         aplVersion,
+		Version:         chartVersion,
 		WaitForJobs:     false,
 	}
 
 	_, err = NewKubePkg(ctx, "aplHelmInstall", &KubePkgArgs{
 		HelmChart: aplChart,
-		Pkg:       "apl-" + aplVersion,
+		Pkg:       "apl-" + chartVersion,
 		Provider:  provider,
 	}, pulumi.DependsOn([]pulumi.Resource{auth, kcloak, api}),
 		pulumi.DeletedWith(provider))
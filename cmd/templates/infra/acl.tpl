@@ -0,0 +1,64 @@
+package app
+
+import (
+	"encoding/json"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+// control plane acl settings from the platform config
+const controlPlaneAclEnabled = {{ if .controlplaneacl }}{{ or .controlplaneacl.enabled false }}{{ else }}false{{ end }}
+
+var (
+	controlPlaneAclIpv4 = []string{
+		{{- if .controlplaneacl }}
+		{{- range .controlplaneacl.ipv4 }}
+		"{{ . }}",
+		{{- end }}
+		{{- end }}
+	}
+	controlPlaneAclIpv6 = []string{
+		{{- if .controlplaneacl }}
+		{{- range .controlplaneacl.ipv6 }}
+		"{{ . }}",
+		{{- end }}
+		{{- end }}
+	}
+)
+
+type controlPlaneAcl struct {
+	Enabled bool     `json:"enabled"`
+	Ipv4    []string `json:"ipv4"`
+	Ipv6    []string `json:"ipv6"`
+}
+
+// clusterControlPlane returns the HA control plane with the acl from the
+// platform config. An acl set with `aplcli acl add/remove` wins over the
+// generated one.
+func clusterControlPlane(ctx *pulumi.Context) (ControlPlane, error) {
+	acl := controlPlaneAcl{
+		Enabled: controlPlaneAclEnabled,
+		Ipv4:    controlPlaneAclIpv4,
+		Ipv6:    controlPlaneAclIpv6,
+	}
+
+	if v, ok := ctx.GetConfig(label + "-infra:controlPlaneAcl"); ok && v != "" {
+		if err := json.Unmarshal([]byte(v), &acl); err != nil {
+			return ControlPlane{}, err
+		}
+	}
+
+	// enterprise clusters come with an HA control plane
+	cp := ControlPlane{
+		Acl: acl.Enabled,
+		HA:  lkeTier != "enterprise",
+	}
+
+	if acl.Enabled {
+		cp.Addrs = []AclAddr{
+			{Ipv4: acl.Ipv4, Ipv6: acl.Ipv6},
+		}
+	}
+
+	return cp, nil
+}
@@ -3,6 +3,7 @@ package app
 import (
 	"errors"
 	"fmt"
+	"maps"
 	"strconv"
 	"strings"
 	"time"
@@ -41,6 +42,7 @@ type ResourceObjects struct {
 	KubeProvider *kubernetes.Provider
 	LkeCluster   *linode.LkeCluster
 	LoadBalancer *StaticLoadbalancer
+	Vpc          *ClusterVpc
 }
 
 type PulumiOpts struct {
@@ -81,9 +83,10 @@ func build(ctx *pulumi.Context, r *PulumiResourceInfo) error {
 		{{- end }}
 	}
 
-	// obj: create a separate, region scoped key
+	// obj: create a separate, region scoped key, labeled with the platform so
+	// gc can tell which platform an orphaned key belongs to
 	objkey, err := linode.NewObjectStorageKey(ctx, "pulumi-obj-key", &linode.ObjectStorageKeyArgs{
-		Label: pulumi.String("pulumi-obj-key"),
+		Label: pulumi.String(label + "-obj-key"),
 		Regions: pulumi.StringArray{
 			pulumi.String(region),
 		},
@@ -107,6 +110,11 @@ func build(ctx *pulumi.Context, r *PulumiResourceInfo) error {
 	// obj: provision buckets
 	buckets := make(map[string]string)
 
+	lifecycles, err := bucketLifecycles(ctx)
+	if err != nil {
+		return err
+	}
+
 	for _, bucket := range objLabels {
 		bucketName := fmt.Sprintf("%s-%s", objPrefix, bucket)
 
@@ -115,7 +123,7 @@ func build(ctx *pulumi.Context, r *PulumiResourceInfo) error {
 			SecretKey:      objkey.SecretKey,
 			Region:         pulumi.String(region),
 			Label:          pulumi.String(bucketName),
-			LifecycleRules: defaultLifecyclePolicy(),
+			LifecycleRules: lifecyclePolicy(lifecycles, bucket),
 		}, pulumi.DependsOn([]pulumi.Resource{objkey}))
 		if err != nil {
 			return err
@@ -169,29 +177,69 @@ func build(ctx *pulumi.Context, r *PulumiResourceInfo) error {
 
 	r.Resources.Domain = domain
 
+	// vpc: attach the cluster to a dedicated or existing vpc subnet
+	vpc, err := clusterVpc(ctx)
+	if err != nil {
+		return err
+	}
+
+	r.Resources.Vpc = vpc
+
 	// lke: configure node pools and control plane options
-	nodePool := NodePool{
-		Autoscaler: true,
-		Labels:     nodeLabels,
-		Tags:       tags,
+	var (
+		firewallId pulumi.IntPtrInput
+		subnetId   pulumi.IntPtrInput
+		vpcId      pulumi.IntPtrInput
+	)
+
+	if vpc != nil {
+		subnetId = vpc.SubnetId
+		vpcId = vpc.VpcId
 	}
 
-	nodePool.SetDefaults()
+	// firewall: attach a cloud firewall to the node pool linodes
+	if firewallEnabled {
+		fw, err := lkeFirewall(ctx, tags, vpc)
+		if err != nil {
+			return err
+		}
 
-	aplNodePool := lkeNodePool(nodePool)
-	cp := ControlPlane{
-		HA: true,
+		firewallId = fw.ID().ApplyT(func(id pulumi.ID) (int, error) {
+			return strconv.Atoi(string(id))
+		}).(pulumi.IntOutput)
+		stackOutputMap["firewallId"] = fw.ID()
 	}
+
+	aplNodePools, err := clusterNodePools(ctx, nodeLabels, tags, firewallId)
+	if err != nil {
+		return err
+	}
+
+	cp, err := clusterControlPlane(ctx)
+	if err != nil {
+		return err
+	}
+
 	aplControlPlane := lkeControlPlane(cp)
 
+	// lke: a version pinned in stack config by `aplcli upgrade` wins over the
+	// version the project was generated with
+	kubeVersion := k8sVersion
+	if v, ok := ctx.GetConfig(label + "-infra:kubeVersion"); ok && v != "" {
+		kubeVersion = v
+	}
+
 	// lke: deploy kubernetes cluster
 	aplcluster, err := linode.NewLkeCluster(ctx, label, &linode.LkeClusterArgs{
-		K8sVersion:   pulumi.String(k8sVersion),
+		K8sVersion:   pulumi.String(kubeVersion),
 		Label:        pulumi.String(label),
-		Pools:        linode.LkeClusterPoolArray{aplNodePool},
+		Pools:        aplNodePools,
 		Region:       pulumi.String(region),
 		ControlPlane: aplControlPlane,
+		SubnetId:     subnetId,
 		Tags:         utils.BuildPulumiStringArray(tags),
+		Tier:         pulumi.String(lkeTier),
+		VpcId:        vpcId,
 	})
 	if err != nil {
 		return err
@@ -257,13 +305,21 @@ func conf(ctx *pulumi.Context, r *PulumiResourceInfo) error {
 		return id, nil
 	}).(pulumi.IntOutput)
 
+	// lke: keep a nodebalancer adopted with the cluster in state
+	if err := adoptedNodeBalancer(ctx); err != nil {
+		return err
+	}
+
 	if ok {
 		// lke: provision static loadbalancer (linode nodebalancer)
 		annotations := map[string]string{
-			"service.beta.kubernetes.io/linode-loadbalancer-tags":     nbTag,
+			"service.beta.kubernetes.io/linode-loadbalancer-tags":     nbTag + ",aplcli-" + label,
 			"service.beta.kubernetes.io/linode-loadbalancer-preserve": "true",
 		}
 
+		// lke: reach backends on their vpc addresses
+		maps.Copy(annotations, nodeBalancerBackendAnnotations(r.Resources.Vpc))
+
 		// lke: deploy a static loadbalancer to the cluster
 		loadbalancer, err := NewStaticLoadbalancer(ctx, nbLabel, &StaticLoadbalancerArgs{
 			Annotations: annotations,
@@ -0,0 +1,119 @@
+package app
+
+import (
+	"cmp"
+	"encoding/json"
+	"slices"
+	"strings"
+
+	"github.com/pulumi/pulumi-linode/sdk/v4/go/linode"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+
+	utils "{{ .repo }}/utils"
+)
+
+// firewall settings from the platform config
+const (
+	firewallEnabled          = {{ if .firewall }}{{ or .firewall.enabled false }}{{ else }}false{{ end }}
+	firewallNodeBalancerOnly = {{ if .firewall }}{{ or .firewall.nodebalanceronly false }}{{ else }}false{{ end }}
+)
+
+// LKE node and NodeBalancer backend networks, and the NodePort range
+const (
+	lkeNodeSubnet      = "192.168.128.0/17"
+	nodeBalancerSubnet = "192.168.255.0/24"
+	nodePorts          = "30000-32767"
+)
+
+var firewallAllowCidrs = []string{
+	{{- if .firewall }}
+	{{- range .firewall.allowcidrs }}
+	"{{ . }}",
+	{{- end }}
+	{{- end }}
+}
+
+// lkeFirewall creates the Cloud Firewall for the node pool Linodes. Inbound
+// traffic is dropped except for the rules LKE needs between nodes, NodePorts
+// from NodeBalancers, and any allowed CIDRs. NodePorts are open to everyone
+// unless firewallNodeBalancerOnly is set. Nodes in a vpc also reach each other
+// and, with vpcBackends, NodeBalancers reach them on their vpc addresses.
+func lkeFirewall(ctx *pulumi.Context, tags []string, vpc *ClusterVpc) (*linode.Firewall, error) {
+	allowCidrs := firewallAllowCidrs
+	nodeSources := []string{lkeNodeSubnet}
+	nbSources := []string{nodeBalancerSubnet}
+
+	if vpc != nil && vpc.SubnetRange != "" {
+		nodeSources = append(nodeSources, vpc.SubnetRange)
+
+		if vpcBackends {
+			nbSources = append(nbSources, cmp.Or(vpcBackendRange, vpc.SubnetRange))
+		}
+	}
+
+	// cidrs set with `aplcli firewall allow/deny` win over the generated list
+	if v, ok := ctx.GetConfig(label + "-infra:firewallAllowCidrs"); ok && v != "" {
+		if err := json.Unmarshal([]byte(v), &allowCidrs); err != nil {
+			return nil, err
+		}
+	}
+
+	inbounds := linode.FirewallInboundArray{
+		inboundRule("lke-kubelet", "TCP", "10250", nodeSources),
+		inboundRule("lke-wireguard", "UDP", "51820", nodeSources),
+		inboundRule("lke-calico-bgp", "TCP", "179", nodeSources),
+		inboundRule("lke-ipencap", "IPENCAP", "", nodeSources),
+		inboundRule("nodebalancer-tcp", "TCP", nodePorts, nbSources),
+		inboundRule("nodebalancer-udp", "UDP", nodePorts, nbSources),
+	}
+
+	if !firewallNodeBalancerOnly {
+		anywhere := []string{"0.0.0.0/0", "::/0"}
+		inbounds = append(inbounds,
+			inboundRule("nodeports-tcp", "TCP", nodePorts, anywhere),
+			inboundRule("nodeports-udp", "UDP", nodePorts, anywhere),
+		)
+	}
+
+	if len(allowCidrs) > 0 {
+		for _, proto := range []string{"TCP", "UDP", "ICMP"} {
+			inbounds = append(inbounds, inboundRule("allow-cidrs-"+strings.ToLower(proto), proto, "", allowCidrs))
+		}
+	}
+
+	fwLabel := label + "-fw"
+
+	return linode.NewFirewall(ctx, fwLabel, &linode.FirewallArgs{
+		Label:          pulumi.String(fwLabel),
+		InboundPolicy:  pulumi.String("DROP"),
+		OutboundPolicy: pulumi.String("ACCEPT"),
+		Inbounds:       inbounds,
+		Tags:           utils.BuildPulumiStringArray(append(slices.Clone(tags), "aplcli-"+label)),
+	})
+}
+
+func inboundRule(name, protocol, ports string, cidrs []string) *linode.FirewallInboundArgs {
+	var ipv4, ipv6 pulumi.StringArray
+
+	for _, i := range cidrs {
+		if strings.Contains(i, ":") {
+			ipv6 = append(ipv6, pulumi.String(i))
+		} else {
+			ipv4 = append(ipv4, pulumi.String(i))
+		}
+	}
+
+	rule := &linode.FirewallInboundArgs{
+		Label:    pulumi.String(name),
+		Action:   pulumi.String("ACCEPT"),
+		Protocol: pulumi.String(protocol),
+		Ipv4s:    ipv4,
+		Ipv6s:    ipv6,
+	}
+
+	if ports != "" {
+		rule.Ports = pulumi.String(ports)
+	}
+
+	return rule
+}
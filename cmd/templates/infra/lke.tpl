@@ -44,12 +44,22 @@ type LkeProviderArgs struct {
 type NodePool struct {
 	Autoscaler bool
 	Count      int
+	FirewallId pulumi.IntPtrInput
 	Labels     map[string]string
 	Max        int
+	Min        int
+	Name       string
 	Tags       []string
+	Taints     []NodeTaint
 	Type       string
 }
 
+type NodeTaint struct {
+	Effect string `json:"effect"`
+	Key    string `json:"key"`
+	Value  string `json:"value"`
+}
+
 type StaticLoadbalancer struct {
 	pulumi.ResourceState
 
@@ -72,14 +82,22 @@ type KubeSvc struct {
 }
 
 func (np *NodePool) SetDefaults() {
+	if np.Count == 0 {
+		np.Count = max(np.Min, 3)
+	}
+
 	if np.Autoscaler {
+		if np.Min == 0 {
+			np.Min = np.Count
+		}
+
 		if np.Max == 0 {
 			np.Max = 15
 		}
-	}
 
-	if np.Count == 0 {
-		np.Count = 3
+		if np.Max < np.Count {
+			np.Max = np.Count
+		}
 	}
 
 	if np.Type == "" {
@@ -89,8 +107,9 @@ func (np *NodePool) SetDefaults() {
 
 func lkeNodePool(np NodePool) linode.LkeClusterPoolArgs {
 	var (
-		autoscale linode.LkeClusterPoolAutoscalerArgs
-		nodeTags  pulumi.StringArray
+		autoscale  linode.LkeClusterPoolAutoscalerArgs
+		nodeTags   pulumi.StringArray
+		nodeTaints linode.LkeClusterPoolTaintArray
 	)
 
 	nodeLabels := pulumi.StringMap{}
@@ -98,7 +117,7 @@ func lkeNodePool(np NodePool) linode.LkeClusterPoolArgs {
 	if np.Autoscaler {
 		autoscale = linode.LkeClusterPoolAutoscalerArgs{
 			Max: pulumi.Int(np.Max),
-			Min: pulumi.Int(np.Count),
+			Min: pulumi.Int(np.Min),
 		}
 	}
 
@@ -114,12 +133,22 @@ func lkeNodePool(np NodePool) linode.LkeClusterPoolArgs {
 		}
 	}
 
+	for _, i := range np.Taints {
+		nodeTaints = append(nodeTaints, linode.LkeClusterPoolTaintArgs{
+			Effect: pulumi.String(i.Effect),
+			Key:    pulumi.String(i.Key),
+			Value:  pulumi.String(i.Value),
+		})
+	}
+
 	nodePool := linode.LkeClusterPoolArgs{
 		Type:       pulumi.String(np.Type),
 		Autoscaler: autoscale,
 		Count:      pulumi.Int(np.Count),
 		Labels:     nodeLabels,
 		Tags:       nodeTags,
+		Taints:     nodeTaints,
+		FirewallId: np.FirewallId,
 	}
 
 	return nodePool
@@ -135,8 +164,8 @@ func lkeControlPlane(cp ControlPlane) linode.LkeClusterControlPlaneArgs {
 		var ips pulumi.StringArray
 
 		for _, i := range f {
-			ip := net.ParseIP(i)
-			if ip != nil {
+			_, _, err := net.ParseCIDR(i)
+			if net.ParseIP(i) != nil || err == nil {
 				ips = append(ips, pulumi.String(i))
 			}
 		}
@@ -154,7 +183,7 @@ func lkeControlPlane(cp ControlPlane) linode.LkeClusterControlPlaneArgs {
 			}
 
 			if utils.AssertResource(i.Ipv6) {
-				addrArgs.Ipv4s = ipArray(i.Ipv4)
+				addrArgs.Ipv6s = ipArray(i.Ipv6)
 			}
 
 			addrs = append(addrs, addrArgs)
@@ -165,8 +194,11 @@ func lkeControlPlane(cp ControlPlane) linode.LkeClusterControlPlaneArgs {
 	}
 
 	controlPlane := linode.LkeClusterControlPlaneArgs{
-		Acl:              aclArgs,
-		HighAvailability: pulumi.Bool(cp.HA),
+		Acl: aclArgs,
+	}
+
+	if cp.HA {
+		controlPlane.HighAvailability = pulumi.Bool(true)
 	}
 
 	return controlPlane
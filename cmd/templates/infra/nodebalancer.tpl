@@ -72,6 +72,23 @@ func GetNodeBalancer(ctx *pulumi.Context, region, tag string, opt ...any) NodeBa
 	return nb
 }
 
+// adoptedNodeBalancer keeps the NodeBalancer imported by `aplcli adopt` in
+// state. The CCM owns it, so its settings are left alone and it is retained
+// in the account when the resource is removed from the stack.
+func adoptedNodeBalancer(ctx *pulumi.Context) error {
+	if id, ok := ctx.GetConfig(label + "-infra:adopted-nodebalancer-id"); !ok || id == "" {
+		return nil
+	}
+
+	_, err := linode.NewNodeBalancer(ctx, label+"-nodebalancer", &linode.NodeBalancerArgs{
+		Region: pulumi.String(region),
+	}, pulumi.RetainOnDelete(true), pulumi.IgnoreChanges([]string{
+		"clientConnThrottle", "clientUdpSessThrottle", "firewallId", "label", "tags",
+	}))
+
+	return err
+}
+
 func searchNodeBalancer(ctx *pulumi.Context, i *GetNodeBalancerInfo) *linode.GetNodebalancersResult {
 	matchMethod := "exact"
 
@@ -0,0 +1,99 @@
+package app
+
+import (
+	"encoding/json"
+	"maps"
+	"slices"
+
+	"github.com/pulumi/pulumi-linode/sdk/v4/go/linode"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+type nodePoolConfig struct {
+	Count  int               `json:"count"`
+	Labels map[string]string `json:"labels"`
+	Max    int               `json:"max"`
+	Min    int               `json:"min"`
+	Name   string            `json:"name"`
+	Tags   []string          `json:"tags"`
+	Taints []NodeTaint       `json:"taints"`
+	Type   string            `json:"type"`
+}
+
+// node pools from the platform config, or a single default pool from
+// nodeCount, nodeMax and nodeType
+var nodePoolsConfig = []nodePoolConfig{
+	{{- if .nodepools }}
+	{{- range .nodepools }}
+	{
+		Count: {{ or .count 0 }},
+		Labels: map[string]string{
+			{{- range $k, $v := .labels }}
+			"{{ $k }}": "{{ $v }}",
+			{{- end }}
+		},
+		Max:  {{ or .max 0 }},
+		Min:  {{ or .min 0 }},
+		Name: "{{ .name }}",
+		Tags: []string{
+			{{- range .tags }}
+			"{{ . }}",
+			{{- end }}
+		},
+		Taints: []NodeTaint{
+			{{- range .taints }}
+			{Effect: "{{ .effect }}", Key: "{{ .key }}", Value: "{{ or .value "" }}"},
+			{{- end }}
+		},
+		Type: "{{ or .type "" }}",
+	},
+	{{- end }}
+	{{- else }}
+	{
+		Count: {{ or .nodecount 3 }},
+		Max:   {{ or .nodemax 15 }},
+		Name:  "default",
+		Type:  "{{ or .nodetype "g6-dedicated-8" }}",
+	},
+	{{- end }}
+}
+
+// clusterNodePools returns the node pools of the cluster. Pools set with
+// `aplcli nodepool scale/add/remove` win over the generated list. Every pool
+// gets the platform labels and tags, a nodepool label with its name, and the
+// node firewall when one is enabled. Pools with a max are autoscaled.
+func clusterNodePools(ctx *pulumi.Context, labels map[string]string, tags []string, fwId pulumi.IntPtrInput) (linode.LkeClusterPoolArray, error) {
+	pools := nodePoolsConfig
+
+	if v, ok := ctx.GetConfig(label + "-infra:nodePools"); ok && v != "" {
+		if err := json.Unmarshal([]byte(v), &pools); err != nil {
+			return nil, err
+		}
+	}
+
+	var poolArray linode.LkeClusterPoolArray
+
+	for _, i := range pools {
+		poolLabels := maps.Clone(labels)
+		maps.Copy(poolLabels, i.Labels)
+		poolLabels["nodepool"] = i.Name
+
+		np := NodePool{
+			Autoscaler: i.Max > 0,
+			Count:      i.Count,
+			FirewallId: fwId,
+			Labels:     poolLabels,
+			Max:        i.Max,
+			Min:        i.Min,
+			Name:       i.Name,
+			Tags:       append(slices.Clone(tags), i.Tags...),
+			Taints:     i.Taints,
+			Type:       i.Type,
+		}
+		np.SetDefaults()
+
+		poolArray = append(poolArray, lkeNodePool(np))
+	}
+
+	return poolArray, nil
+}
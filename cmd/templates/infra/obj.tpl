@@ -1,21 +1,93 @@
 package app
 
 import (
+	"encoding/json"
+	"maps"
+
 	"github.com/pulumi/pulumi-linode/sdk/v4/go/linode"
 	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
 )
 
-func defaultLifecyclePolicy() linode.ObjectStorageBucketLifecycleRuleArray {
-	lifecyclePolicy := linode.ObjectStorageBucketLifecycleRuleArray{
-		&linode.ObjectStorageBucketLifecycleRuleArgs{
-			Id:                                 pulumi.String("global-expiration-policy"),
-			Enabled:                            pulumi.Bool(true),
-			AbortIncompleteMultipartUploadDays: pulumi.Int(5),
-			Expiration: &linode.ObjectStorageBucketLifecycleRuleExpirationArgs{
-				Days: pulumi.Int(90),
-			},
+// lifecycleRuleID is the id of the platform rule, which `aplcli obj
+// lifecycle` also manages.
+const lifecycleRuleID = "{{ .lifecycleruleid }}"
+
+// lifecycleDays are the days of the lifecycle rule of a bucket, zero days
+// turn a rule off.
+type lifecycleDays struct {
+	ExpirationDays           int `json:"expirationDays"`
+	NoncurrentExpirationDays int `json:"noncurrentExpirationDays"`
+	AbortMultipartDays       int `json:"abortMultipartDays"`
+}
+
+// the lifecycle of buckets from the platform config, with defaults filled in
+// by aplcli, and of the buckets without one
+var (
+	bucketLifecycle = map[string]lifecycleDays{
+		{{- range $k, $v := .bucketlifecycle }}
+		"{{ $k }}": {
+			ExpirationDays:           {{ $v.ExpirationDays }},
+			NoncurrentExpirationDays: {{ $v.NoncurrentExpirationDays }},
+			AbortMultipartDays:       {{ $v.AbortMultipartDays }},
 		},
+		{{- end }}
+	}
+	defaultLifecycle = lifecycleDays{
+		ExpirationDays:           {{ .lifecycledefault.ExpirationDays }},
+		NoncurrentExpirationDays: {{ .lifecycledefault.NoncurrentExpirationDays }},
+		AbortMultipartDays:       {{ .lifecycledefault.AbortMultipartDays }},
+	}
+)
+
+// bucketLifecycles returns the lifecycle days per bucket. Rules set with
+// `aplcli obj lifecycle apply` win over the generated ones.
+func bucketLifecycles(ctx *pulumi.Context) (map[string]lifecycleDays, error) {
+	days := maps.Clone(bucketLifecycle)
+
+	if v, ok := ctx.GetConfig(label + "-infra:bucketLifecycle"); ok && v != "" {
+		pinned := make(map[string]lifecycleDays)
+		if err := json.Unmarshal([]byte(v), &pinned); err != nil {
+			return nil, err
+		}
+
+		maps.Copy(days, pinned)
+	}
+
+	return days, nil
+}
+
+// lifecyclePolicy returns the lifecycle rule of a bucket such as loki, or no
+// rules when every action is turned off.
+func lifecyclePolicy(lifecycles map[string]lifecycleDays, bucket string) linode.ObjectStorageBucketLifecycleRuleArray {
+	days, ok := lifecycles[bucket]
+	if !ok {
+		days = defaultLifecycle
+	}
+
+	if days == (lifecycleDays{}) {
+		return linode.ObjectStorageBucketLifecycleRuleArray{}
+	}
+
+	rule := &linode.ObjectStorageBucketLifecycleRuleArgs{
+		Id:      pulumi.String(lifecycleRuleID),
+		Enabled: pulumi.Bool(true),
+	}
+
+	if days.AbortMultipartDays > 0 {
+		rule.AbortIncompleteMultipartUploadDays = pulumi.Int(days.AbortMultipartDays)
+	}
+
+	if days.ExpirationDays > 0 {
+		rule.Expiration = &linode.ObjectStorageBucketLifecycleRuleExpirationArgs{
+			Days: pulumi.Int(days.ExpirationDays),
+		}
+	}
+
+	if days.NoncurrentExpirationDays > 0 {
+		rule.NoncurrentVersionExpiration = &linode.ObjectStorageBucketLifecycleRuleNoncurrentVersionExpirationArgs{
+			Days: pulumi.Int(days.NoncurrentExpirationDays),
+		}
 	}
 
-	return lifecyclePolicy
+	return linode.ObjectStorageBucketLifecycleRuleArray{rule}
 }
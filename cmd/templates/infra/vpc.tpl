@@ -0,0 +1,158 @@
+package app
+
+import (
+	"errors"
+	"strconv"
+
+	"github.com/pulumi/pulumi-linode/sdk/v4/go/linode"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+// lke tier and vpc settings from the platform config
+const (
+	lkeTier         = "{{ or .lketier "standard" }}"
+	vpcDedicated    = {{ if .vpc }}{{ or .vpc.dedicated false }}{{ else }}false{{ end }}
+	vpcLabel        = "{{ if .vpc }}{{ or .vpc.label "" }}{{ end }}"
+	vpcSubnetLabel  = "{{ if .vpc }}{{ or .vpc.subnetlabel "" }}{{ end }}"
+	vpcSubnetRange  = "{{ if .vpc }}{{ or .vpc.subnet "10.0.0.0/16" }}{{ else }}10.0.0.0/16{{ end }}"
+	vpcBackends     = {{ if .vpc }}{{ or .vpc.backends false }}{{ else }}false{{ end }}
+	vpcBackendRange = "{{ if .vpc }}{{ or .vpc.backendrange "" }}{{ end }}"
+)
+
+type ClusterVpc struct {
+	SubnetId    pulumi.IntPtrInput
+	SubnetLabel string
+	SubnetRange string
+	VpcId       pulumi.IntPtrInput
+	VpcLabel    string
+}
+
+// clusterVpc returns the VPC subnet the cluster is attached to: a dedicated
+// <label>-vpc created with the cluster, an existing VPC found by label, or nil
+// when the cluster uses the default LKE networking.
+func clusterVpc(ctx *pulumi.Context) (*ClusterVpc, error) {
+	atoi := func(id pulumi.ID) (int, error) {
+		return strconv.Atoi(string(id))
+	}
+
+	switch {
+	case vpcDedicated:
+		vpc, err := linode.NewVpc(ctx, label+"-vpc", &linode.VpcArgs{
+			Label:       pulumi.String(label + "-vpc"),
+			Region:      pulumi.String(region),
+			Description: pulumi.String("App Platform " + label + " " + stack),
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		vpcId := vpc.ID().ApplyT(atoi).(pulumi.IntOutput)
+
+		subnet, err := linode.NewVpcSubnet(ctx, label+"-subnet", &linode.VpcSubnetArgs{
+			VpcId: vpcId,
+			Label: pulumi.String(label + "-subnet"),
+			Ipv4:  pulumi.String(vpcSubnetRange),
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		stackOutputMap["vpcId"] = vpc.ID()
+
+		return &ClusterVpc{
+			SubnetId:    subnet.ID().ApplyT(atoi).(pulumi.IntOutput),
+			SubnetLabel: label + "-subnet",
+			SubnetRange: vpcSubnetRange,
+			VpcId:       vpcId,
+			VpcLabel:    label + "-vpc",
+		}, nil
+	case vpcLabel != "":
+		return lookupVpc(ctx)
+	}
+
+	return nil, nil
+}
+
+// lookupVpc finds an existing VPC and subnet by label in the platform region.
+func lookupVpc(ctx *pulumi.Context) (*ClusterVpc, error) {
+	if vpcSubnetLabel == "" {
+		return nil, errors.New("vpc " + vpcLabel + ": subnetLabel is required")
+	}
+
+	matchMethod := "exact"
+
+	vpcs, err := linode.GetVpcs(ctx, &linode.GetVpcsArgs{
+		Filters: []linode.GetVpcsFilter{
+			{
+				Name:    "label",
+				Values:  []string{vpcLabel},
+				MatchBy: &matchMethod,
+			},
+			{
+				Name:    "region",
+				Values:  []string{region},
+				MatchBy: &matchMethod,
+			},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(vpcs.Vpcs) == 0 {
+		return nil, errors.New("vpc " + vpcLabel + " not found in " + region)
+	}
+
+	vpcId, err := strconv.Atoi(vpcs.Vpcs[0].Id)
+	if err != nil {
+		return nil, err
+	}
+
+	subnets, err := linode.GetVpcSubnets(ctx, &linode.GetVpcSubnetsArgs{
+		VpcId: vpcId,
+		Filters: []linode.GetVpcSubnetsFilter{
+			{
+				Name:    "label",
+				Values:  []string{vpcSubnetLabel},
+				MatchBy: &matchMethod,
+			},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(subnets.VpcSubnets) == 0 {
+		return nil, errors.New("subnet " + vpcSubnetLabel + " not found in vpc " + vpcLabel)
+	}
+
+	subnet := subnets.VpcSubnets[0]
+
+	return &ClusterVpc{
+		SubnetId:    pulumi.Int(subnet.Id),
+		SubnetLabel: vpcSubnetLabel,
+		SubnetRange: subnet.Ipv4,
+		VpcId:       pulumi.Int(vpcId),
+		VpcLabel:    vpcLabel,
+	}, nil
+}
+
+// nodeBalancerBackendAnnotations have the cloud controller manager reach the
+// NodeBalancer backends on their VPC addresses. The nodes keep their public
+// IPs, only NodeBalancer traffic moves to the VPC.
+func nodeBalancerBackendAnnotations(vpc *ClusterVpc) map[string]string {
+	annotations := map[string]string{}
+
+	if vpc == nil || !vpcBackends {
+		return annotations
+	}
+
+	annotations["service.beta.kubernetes.io/linode-loadbalancer-backend-vpc-name"] = vpc.VpcLabel
+	annotations["service.beta.kubernetes.io/linode-loadbalancer-backend-subnet-name"] = vpc.SubnetLabel
+
+	if vpcBackendRange != "" {
+		annotations["service.beta.kubernetes.io/linode-loadbalancer-backend-ipv4-range"] = vpcBackendRange
+	}
+
+	return annotations
+}
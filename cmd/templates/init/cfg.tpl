@@ -15,11 +15,50 @@ platform:
     repo: {{ .repo }}
     values: *values
   # aplVersion:
+  # controlPlaneAcl:
+  #   enabled: true
+  #   ipv4: []
+  #   ipv6: []
+  # firewall:
+  #   enabled: true
+  #   nodeBalancerOnly: true
+  #   allowCidrs: []
   # kubeVersion:  
+  # nodePools:
+  #   - name: default
+  #     type: g6-dedicated-8
+  #     count: 3
+  #     max: 15
+  #   - name: observability
+  #     type: g7-highmem-2
+  #     count: 2
+  #     labels:
+  #       workload: observability
+  #     taints:
+  #       - key: workload
+  #         value: observability
+  #         effect: NoSchedule
+  # lkeTier: enterprise
+  # vpc:
+  #   dedicated: true
+  #   subnet: 10.0.0.0/16
+  #   backends: true
+  #   backendRange: 10.100.0.0/30
+  # lifecycle:
+  #   loki:
+  #     expirationDays: 30
+  #     noncurrentExpirationDays: 7
+  #     abortMultipartDays: 5
+  #   velero:
+  #     expirationDays: -1
   # nbTag:        
   # nodeCount:
   # nodeMax:      
   # nodeType:     
   # objPrefix:    
+  # secretStore:
+  # sharedEnv:
   # stack:  
   # tags: []
+  # vaultAddr:
+  # vaultMount:
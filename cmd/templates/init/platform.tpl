@@ -0,0 +1,131 @@
+  - name: {{ .name }}
+    domain: {{ .domain }}
+    email: {{ .email }}
+    {{- if .controlplaneacl }}
+    controlPlaneAcl:
+      enabled: {{ or .controlplaneacl.enabled false }}
+      {{- if .controlplaneacl.ipv4 }}
+      ipv4:
+      {{- range .controlplaneacl.ipv4 }}
+        - {{ . }}
+      {{- end }}
+      {{- end }}
+      {{- if .controlplaneacl.ipv6 }}
+      ipv6:
+      {{- range .controlplaneacl.ipv6 }}
+        - {{ . }}
+      {{- end }}
+      {{- end }}
+    {{- end }}
+    {{- if .firewall }}
+    firewall:
+      enabled: {{ or .firewall.enabled false }}
+      nodeBalancerOnly: {{ or .firewall.nodebalanceronly false }}
+      {{- if .firewall.allowcidrs }}
+      allowCidrs:
+      {{- range .firewall.allowcidrs }}
+        - {{ . }}
+      {{- end }}
+      {{- end }}
+    {{- end }}
+    region: {{ .region }}
+    repo: {{ .repo }}
+    values: {{ .values }}
+    aplVersion: {{ .aplversion }}
+    kubeVersion: {{ .kubeversion }}
+    {{- if .lifecycle }}
+    lifecycle:
+    {{- range $k, $v := .lifecycle }}
+      {{ $k }}:
+      {{- if $v.expirationdays }}
+        expirationDays: {{ $v.expirationdays }}
+      {{- end }}
+      {{- if $v.noncurrentexpirationdays }}
+        noncurrentExpirationDays: {{ $v.noncurrentexpirationdays }}
+      {{- end }}
+      {{- if $v.abortmultipartdays }}
+        abortMultipartDays: {{ $v.abortmultipartdays }}
+      {{- end }}
+    {{- end }}
+    {{- end }}
+    {{- if .lketier }}
+    lkeTier: {{ .lketier }}
+    {{- end }}
+    nbTag: {{ .nbtag }}
+    nodeCount: {{ .nodecount }}
+    nodeMax: {{ .nodemax }}
+    nodeType: {{ .nodetype }}
+    {{- if .nodepools }}
+    nodePools:
+    {{- range .nodepools }}
+      - name: {{ .name }}
+        {{- if .type }}
+        type: {{ .type }}
+        {{- end }}
+        {{- if .count }}
+        count: {{ .count }}
+        {{- end }}
+        {{- if .min }}
+        min: {{ .min }}
+        {{- end }}
+        {{- if .max }}
+        max: {{ .max }}
+        {{- end }}
+        {{- if .labels }}
+        labels:
+        {{- range $k, $v := .labels }}
+          {{ $k }}: {{ $v }}
+        {{- end }}
+        {{- end }}
+        {{- if .taints }}
+        taints:
+        {{- range .taints }}
+          - key: {{ .key }}
+            {{- if .value }}
+            value: {{ .value }}
+            {{- end }}
+            effect: {{ .effect }}
+        {{- end }}
+        {{- end }}
+        {{- if .tags }}
+        tags:
+        {{- range .tags }}
+          - {{ . }}
+        {{- end }}
+        {{- end }}
+    {{- end }}
+    {{- end }}
+    objPrefix: {{ .objprefix }}
+    secretStore: {{ or .secretstore "esc" }}
+    {{- if .agekeyfile }}
+    ageKeyFile: {{ .agekeyfile }}
+    {{- end }}
+    {{- if .sharedenv }}
+    sharedEnv: {{ .sharedenv }}
+    {{- end }}
+    stack: {{ .stack }}
+    {{- if .vpc }}
+    vpc:
+      {{- if .vpc.dedicated }}
+      dedicated: true
+      {{- end }}
+      {{- if .vpc.label }}
+      label: {{ .vpc.label }}
+      {{- end }}
+      {{- if .vpc.subnetlabel }}
+      subnetLabel: {{ .vpc.subnetlabel }}
+      {{- end }}
+      {{- if .vpc.subnet }}
+      subnet: {{ .vpc.subnet }}
+      {{- end }}
+      {{- if .vpc.backends }}
+      backends: true
+      {{- end }}
+      {{- if .vpc.backendrange }}
+      backendRange: {{ .vpc.backendrange }}
+      {{- end }}
+    {{- end }}
+    tags:
+    {{- range .tags }}
+      - {{ . }}
+    {{- end }}
@@ -1,5 +1,7 @@
+{{- if eq (or .secretstore "esc") "esc" }}
 environment:
   - {{.name }}/{{ .stack }}
+{{- end }}
 config:
   pulumi:tags:
     pulumi:template: linode-go
@@ -1,21 +1,33 @@
 package internal
 
 import (
+	"context"
 	"crypto/rand"
 	"encoding/base64"
+	"errors"
 	"fmt"
 	"maps"
+	"net/url"
 	"slices"
 	"strings"
+	"sync"
+	"time"
 
 	age "filippo.io/age"
 	esc "github.com/pulumi/esc-sdk/sdk/go"
+	esc_workspace "github.com/pulumi/esc/cmd/esc/cli/workspace"
 	"github.com/pulumi/pulumi/sdk/v3/go/pulumix"
 )
 
+const escUpdateRetries = 5
+
+// escMu serializes read-merge-write updates within a process.
+var escMu sync.Mutex
+
 type EscEnv struct {
 	EnvName    string
 	Config     map[string]any
+	Imports    []string
 	Items      map[int]EscEnvItem
 	OrgName    string
 	ProjName   string
@@ -56,6 +68,14 @@ func EscExists(orgName, projName, envName string) bool {
 }
 
 func (e *EscEnv) Init() {
+	if secretStore != "esc" {
+		if err := NewSecretStore(e.OrgName, e.ProjName, e.EnvName).Init(e); err != nil {
+			logger.Error("initialize secret store: " + err.Error())
+		}
+
+		return
+	}
+
 	authCtx, escClient, err := esc.DefaultLogin()
 	if err != nil {
 		logger.Error("pulumi esc init login: " + err.Error())
@@ -86,69 +106,88 @@ func (e *EscEnv) AddConfig(c map[string]any) *EscEnv {
 	return e
 }
 
-func (e *EscEnv) GetConfig(key string) (string, int) {
-	authCtx, escClient, err := esc.DefaultLogin()
-	if err != nil {
-		logger.Error("auth to esc environment for update: " + err.Error())
-	}
-
-	_, config, err := escClient.OpenAndReadEnvironment(authCtx, e.OrgName, e.ProjName, e.EnvName)
-	if err != nil {
-		logger.Error("open and read esc environment: " + err.Error())
-	}
+func (e *EscEnv) Update() {
+	if secretStore != "esc" {
+		if err := NewSecretStore(e.OrgName, e.ProjName, e.EnvName).Update(e); err != nil {
+			logger.Error("update secret store: " + err.Error())
+		}
 
-	value, ok := config[key]
-	if !ok {
-		msg := fmt.Sprintf("\"%s\" not found", key)
-		logger.Error("get value from esc environment: " + msg)
+		return
 	}
 
-	switch v := value.(type) {
-	case string:
-		return v, 0
-	case int:
-		return "", v
-	default:
-		return "", 0
-	}
-}
+	// updates issued from concurrent ApplyT callbacks in one process are
+	// serialized, other writers are detected by etag and re-merged
+	escMu.Lock()
+	defer escMu.Unlock()
 
-func (e *EscEnv) Update() {
 	authCtx, escClient, err := esc.DefaultLogin()
 	if err != nil {
 		logger.Error("auth to esc environment for update: " + err.Error())
 	}
 
-	env, _, err := escClient.GetEnvironment(authCtx, e.OrgName, e.ProjName, e.EnvName)
-	if err != nil {
-		logger.Error("get existing esc environment: " + err.Error())
-	}
+	config := maps.Clone(e.Config)
+	imports := slices.Clone(e.Imports)
 
-	// merge new and existing pulumiConfig maps
-	c := env.Values.PulumiConfig
-	maps.Copy(c, e.Config)
-	e.Config = c
+	for attempt := 1; attempt <= escUpdateRetries; attempt++ {
+		env, etag, err := getEnvironment(authCtx, escClient, e.OrgName, e.ProjName, e.EnvName)
+		if err != nil {
+			logger.Error("get existing esc environment: " + err.Error())
 
-	// build new values map from new and existing
-	v, err := env.GetValues().ToMap()
-	if err != nil {
-		logger.Error("get existing esc pulumi config: " + err.Error())
-	}
+			return
+		}
 
-	values := v
+		// merge new and existing pulumiConfig maps
+		c := env.GetValues().PulumiConfig
+		if c == nil {
+			c = make(map[string]any)
+		}
+
+		maps.Copy(c, config)
+		e.Config = c
 
-	switch {
-	case len(e.Objects) > 0:
-		for _, i := range e.Objects {
-			values[i.Name] = i.Value
+		// keep existing imports, new ones are appended
+		e.Imports = slices.Clone(env.Imports)
+		for _, i := range imports {
+			if !slices.Contains(e.Imports, i) {
+				e.Imports = append(e.Imports, i)
+			}
 		}
-	case len(e.Items) > 0:
-		values = e.BuildValues(values)
-	}
 
-	if err := e.Write(values); err != nil {
-		logger.Error("write update to esc environment: " + err.Error())
+		// build new values map from new and existing
+		v, err := env.GetValues().ToMap()
+		if err != nil {
+			logger.Error("get existing esc pulumi config: " + err.Error())
+		}
+
+		values := v
+
+		switch {
+		case len(e.Objects) > 0:
+			for _, i := range e.Objects {
+				values[i.Name] = i.Value
+			}
+		case len(e.Items) > 0:
+			values = e.BuildValues(values)
+		}
+
+		err = e.writeIfMatch(authCtx, values, etag)
+		if err == nil {
+			return
+		}
+
+		if !isEscConflict(err) {
+			logger.Error("write update to esc environment: " + err.Error())
+
+			return
+		}
+
+		msg := fmt.Sprintf("esc environment changed during update: retrying (%d/%d)", attempt, escUpdateRetries)
+		logger.Warn(msg)
+
+		time.Sleep(time.Duration(attempt) * time.Second)
 	}
+
+	logger.Error("write update to esc environment: too many conflicting writes")
 }
 
 func (e *EscEnv) Write(values map[string]any) error {
@@ -158,6 +197,7 @@ func (e *EscEnv) Write(values map[string]any) error {
 	}
 
 	payload := &esc.EnvironmentDefinition{
+		Imports: e.Imports,
 		Values: &esc.EnvironmentDefinitionValues{
 			PulumiConfig:         e.Config,
 			AdditionalProperties: values,
@@ -172,6 +212,69 @@ func (e *EscEnv) Write(values map[string]any) error {
 	return nil
 }
 
+// writeIfMatch writes the environment only if it is unchanged since the etag
+// was read. ESC rejects a stale etag with a conflict.
+func (e *EscEnv) writeIfMatch(authCtx context.Context, values map[string]any, etag string) error {
+	if etag == "" {
+		return e.Write(values)
+	}
+
+	cfg, err := escConfiguration()
+	if err != nil {
+		return err
+	}
+
+	cfg.AddDefaultHeader("If-Match", etag)
+	escClient := esc.NewClient(cfg)
+
+	payload := &esc.EnvironmentDefinition{
+		Imports: e.Imports,
+		Values: &esc.EnvironmentDefinitionValues{
+			PulumiConfig:         e.Config,
+			AdditionalProperties: values,
+		},
+	}
+
+	_, err = escClient.UpdateEnvironment(authCtx, e.OrgName, e.ProjName, e.EnvName, payload)
+
+	return err
+}
+
+// escConfiguration returns the client configuration for the backend the
+// pulumi or esc CLI is logged in to, resolved the same way esc.DefaultLogin
+// does, so self-hosted backends get the write too.
+func escConfiguration() (*esc.Configuration, error) {
+	ws := esc_workspace.New(esc_workspace.DefaultFS(), esc_workspace.DefaultPulumiWorkspace())
+
+	account, _, err := ws.GetCurrentAccount(false)
+	if err != nil {
+		return nil, errors.New("get current pulumi account: " + err.Error())
+	}
+
+	backendURL, err := url.Parse(ws.GetCurrentCloudURL(account))
+	if err != nil {
+		return nil, errors.New("parse pulumi backend url: " + err.Error())
+	}
+
+	return esc.NewCustomBackendConfiguration(*backendURL)
+}
+
+// getEnvironment returns the environment definition along with the etag
+// needed for a conditional write.
+func getEnvironment(ctx context.Context, c *esc.EscClient, org, proj, env string) (*esc.EnvironmentDefinition, string, error) {
+	def, res, err := c.EscAPI.GetEnvironment(ctx, org, proj, env).Execute()
+	if err != nil {
+		return nil, "", err
+	}
+
+	return def, res.Header.Get("ETag"), nil
+}
+
+func isEscConflict(err error) bool {
+	return strings.Contains(err.Error(), "409 Conflict") ||
+		strings.Contains(err.Error(), "412 Precondition Failed")
+}
+
 func (e *EscEnv) Remove() {
 	authCtx, escClient, err := esc.DefaultLogin()
 	if err != nil {
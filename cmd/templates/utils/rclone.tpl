@@ -4,8 +4,15 @@ package internal
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
 
 	_ "github.com/rclone/rclone/backend/all"
 	_ "github.com/rclone/rclone/fs/operations" // import operations/*
@@ -15,6 +22,17 @@ import (
 
 {{ rcloneHeader }}
 
+const (
+	purgeWorkers       = 4
+	rclonePollInterval = 2 * time.Second
+)
+
+var (
+	rcloneInit sync.Once
+	// rcloneSecret matches credentials in connection strings.
+	rcloneSecret = regexp.MustCompile(`(access_key_id|secret_access_key)=("(?:[^"]|"")*"|'(?:[^']|'')*'|[^,:]*)`)
+)
+
 type syncRequest struct {
 	SrcFs     string `json:"srcFs"`
 	DstFs     string `json:"dstFs"`
@@ -27,6 +45,8 @@ type PurgeRequest struct {
 	Fs     string `json:"fs"`
 	Remote string `json:"remote"`
 	RmDirs bool   `json:"rmdirs,omitempty"` // add --rmDirs flag to rclone delete command
+	Group  string `json:"_group,omitempty"`
+	Async  bool   `json:"_async,omitempty"`
 }
 
 type s3Remote struct {
@@ -38,6 +58,7 @@ type s3Remote struct {
 	PurgeEnabled    bool              `json:"purge,omitempty"`
 	Remote          string            `json:"name,omitempty"`
 	SecretAccessKey string            `json:"secretKey,omitempty"`
+	StateFile       string            `json:"-"`
 }
 
 func (r *s3Remote) Init(ctx context.Context) {
@@ -63,30 +84,54 @@ func (r *s3Remote) Init(ctx context.Context) {
 	if r.Acl == "" {
 		r.Acl = "private"
 	}
-	// https://rclone.org/s3/#magalu
-	envVars := map[string]string{
-		"RCLONE_S3_ACCESS_KEY_ID":     r.AccessKeyId,
-		"RCLONE_S3_ACL":               r.Acl,
-		"RCLONE_S3_ENDPOINT":          r.Endpoint,
-		"RCLONE_S3_ENV_AUTH":          "true",
-		"RCLONE_S3_PROVIDER":          r.Provider,
-		"RCLONE_S3_SECRET_ACCESS_KEY": r.SecretAccessKey,
-	}
-	for k, v := range envVars {
-		if err := os.Setenv(k, v); err != nil {
-			msg := fmt.Sprintf("set `%s=%s` rclone environment variable", k, v)
-			logger.Error(msg + err.Error())
-		}
+}
+
+// Fs returns an rclone connection string for a bucket, or for the endpoint
+// when bucket is empty. Credentials are part of each remote rather than the
+// process environment, so remotes for different endpoints and keys can be
+// used at the same time.
+// https://rclone.org/docs/#connection-strings
+func (r *s3Remote) Fs(bucket string) string {
+	params := []string{
+		"provider=" + rcloneQuote(r.Provider),
+		"env_auth=false",
+		"access_key_id=" + rcloneQuote(r.AccessKeyId),
+		"secret_access_key=" + rcloneQuote(r.SecretAccessKey),
+		"endpoint=" + rcloneQuote(r.Endpoint),
+		"acl=" + rcloneQuote(r.Acl),
 	}
+
+	return ":s3," + strings.Join(params, ",") + ":" + bucket
 }
 
-func (r *s3Remote) Purge(ctx context.Context, bkt ...string) {
-	methods := map[string]string{
-		"list":   "operations/list",
-		"delete": "operations/delete",
-		"purge":  "operations/purge",
+// rcloneQuote quotes a connection string parameter value, doubling quotes
+// inside it.
+func rcloneQuote(v string) string {
+	return `"` + strings.ReplaceAll(v, `"`, `""`) + `"`
+}
+
+// rcloneError returns the error message of an rc response without its input
+// parameters, with credentials in connection strings masked.
+func rcloneError(method string, status int, res string) error {
+	var e struct {
+		Error string `json:"error"`
+	}
+
+	msg := res
+	if err := json.Unmarshal([]byte(res), &e); err == nil && e.Error != "" {
+		msg = e.Error
 	}
 
+	msg = rcloneSecret.ReplaceAllString(msg, "$1=***")
+
+	return fmt.Errorf("%s: status %d: %s", method, status, msg)
+}
+
+// Purge deletes the objects in the buckets, or all platform buckets, as async
+// rclone jobs running purgeWorkers buckets at a time. When StateFile is set,
+// finished buckets are recorded in it so an interrupted purge resumes with the
+// remaining buckets.
+func (r *s3Remote) Purge(ctx context.Context, bkt ...string) error {
 	buckets := make([]string, 0)
 	if len(bkt) > 0 {
 		buckets = bkt
@@ -96,66 +141,314 @@ func (r *s3Remote) Purge(ctx context.Context, bkt ...string) {
 		}
 	}
 
+	slices.Sort(buckets)
+
+	state, err := loadPurgeState(r.StateFile)
+	if err != nil {
+		return err
+	}
+
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		errs []error
+	)
+
+	sem := make(chan struct{}, purgeWorkers)
+
 	for idx, bucket := range buckets {
-		var method string
-
-		idx++
-		f := ":s3:" + bucket
-		purgeReq := PurgeRequest{
-			// To use without ENV_AUTH, the string value for FS needs to be
-			// formatted like this:
-			// ":s3,provider=Other,env_auth=false,access_key_id=<ACCESS_KEY>,secret_access_key=<SECRET_ACCESS_KEY>,endpoint='nl-ams-1.linodeobjects.com':<BUCKET>,
-			Fs:     f,
-			Remote: bucket,
-		}
+		prefix := fmt.Sprintf("(%d/%d)", idx+1, len(buckets))
 
-		// skip bucket if does not exist
-		method = methods["list"]
+		if state.done(bucket) {
+			logger.Info(prefix + " bucket " + bucket + " already purged")
 
-		requestJSON, err := json.Marshal(purgeReq)
-		if err != nil {
-			logger.Error("json marshal rclone bucket list request: " + err.Error())
+			continue
 		}
 
-		res, status := rcloneAction(method, string(requestJSON))
-		if status != 200 {
-			if status == 404 {
-				msg := fmt.Sprintf("(%d/%d) skipping bucket %s", idx, len(buckets), bucket)
-				logger.Info(msg)
-
-				continue
-			} else {
-				msg := fmt.Sprintf("list bucket operation: status %v, response: %v", status, res)
-				logger.Error(msg)
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			if err := r.purgeBucket(ctx, prefix, bucket); err != nil {
+				mu.Lock()
+				errs = append(errs, fmt.Errorf("bucket %s: %w", bucket, err))
+				mu.Unlock()
+
+				return
+			}
+
+			if err := state.finish(bucket); err != nil {
+				logger.Warn("record purged bucket " + bucket + ": " + err.Error())
 			}
+		}()
+	}
+
+	wg.Wait()
+
+	if len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+
+	return state.remove()
+}
+
+func (r *s3Remote) purgeBucket(ctx context.Context, prefix, bucket string) error {
+	// list and delete run on the bucket as their fs: operations/delete ignores
+	// remote, so an endpoint fs would delete objects in every bucket
+	purgeReq := PurgeRequest{Fs: r.Fs(bucket)}
+
+	// skip bucket if does not exist
+	requestJSON, err := json.Marshal(purgeReq)
+	if err != nil {
+		return err
+	}
+
+	res, status := rcloneAction("operations/list", string(requestJSON))
+	if status == 404 {
+		logger.Info(prefix + " skipping bucket " + bucket)
+
+		return nil
+	}
+
+	if status != 200 {
+		return rcloneError("operations/list", status, res)
+	}
+
+	count, size, err := rcloneSize(r.Fs(bucket))
+	if err != nil {
+		return err
+	}
+
+	method := "operations/delete"
+	purgeReq.RmDirs = true
+
+	// purge removes the bucket itself, so it takes the endpoint and the bucket
+	// as remote
+	if r.PurgeEnabled {
+		purgeReq = PurgeRequest{Fs: r.Fs(""), Remote: bucket}
+		method = "operations/purge"
+	}
+
+	purgeReq.Group = "purge/" + bucket
+	purgeReq.Async = true
+
+	msg := fmt.Sprintf("%s deleting %d objects (%s) in bucket %s", prefix, count, formatBytes(size), bucket)
+	logger.Info(msg)
+
+	_, err = rcloneJob(ctx, method, purgeReq, purgeReq.Group, func(st rcloneStats) {
+		msg := fmt.Sprintf("%s purging %s: %d/%d objects deleted", prefix, bucket, st.Deletes, count)
+		logger.Info(msg)
+	})
+	if err != nil {
+		return err
+	}
+
+	// a purged bucket no longer exists, so everything it held was deleted
+	deleted := size
+	if !r.PurgeEnabled {
+		if _, left, err := rcloneSize(r.Fs(bucket)); err == nil {
+			deleted = size - left
+		}
+	}
+
+	msg = fmt.Sprintf("%s deleted %s from bucket %s", prefix, formatBytes(deleted), bucket)
+	logger.Info(msg)
+
+	return nil
+}
+
+// purgeState records the buckets a purge has finished in a json file.
+type purgeState struct {
+	mu      sync.Mutex
+	file    string
+	Buckets map[string]time.Time `json:"buckets"`
+}
+
+func loadPurgeState(file string) (*purgeState, error) {
+	state := &purgeState{file: file, Buckets: make(map[string]time.Time)}
+	if file == "" {
+		return state, nil
+	}
+
+	f, err := os.ReadFile(file)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return state, nil
 		}
 
-		if !r.PurgeEnabled {
-			purgeReq.RmDirs = true
-			method = methods["delete"]
-		} else {
-			method = methods["purge"]
+		return nil, err
+	}
+
+	if err := json.Unmarshal(f, state); err != nil {
+		return nil, errors.New("json unmarshal purge state: " + err.Error())
+	}
+
+	logger.Info("resuming purge recorded in " + file)
+
+	return state, nil
+}
+
+func (s *purgeState) done(bucket string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, ok := s.Buckets[bucket]
+
+	return ok
+}
+
+func (s *purgeState) finish(bucket string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.Buckets[bucket] = time.Now().UTC()
+	if s.file == "" {
+		return nil
+	}
+
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(s.file), 0700); err != nil {
+		return err
+	}
+
+	return os.WriteFile(s.file, data, 0600)
+}
+
+// remove deletes the state file once every bucket is purged.
+func (s *purgeState) remove() error {
+	if s.file == "" {
+		return nil
+	}
+
+	if err := os.Remove(s.file); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	return nil
+}
+
+// rcloneSize returns the object count and total size of an rclone fs.
+func rcloneSize(fsName string) (int64, int64, error) {
+	var size struct {
+		Count int64 `json:"count"`
+		Bytes int64 `json:"bytes"`
+	}
+
+	res, status := rcloneAction("operations/size", fmt.Sprintf(`{"fs": %q}`, fsName))
+	if status != 200 {
+		return 0, 0, rcloneError("operations/size", status, res)
+	}
+
+	if err := json.Unmarshal([]byte(res), &size); err != nil {
+		return 0, 0, err
+	}
+
+	return size.Count, size.Bytes, nil
+}
+
+func formatBytes(n int64) string {
+	const unit = 1024
+
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+
+	div, exp := int64(unit), 0
+	for i := n / unit; i >= unit; i /= unit {
+		div *= unit
+		exp++
+	}
+
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// rcloneStats is the subset of the core/stats response used for progress.
+type rcloneStats struct {
+	Bytes          int64 `json:"bytes"`
+	Deletes        int64 `json:"deletes"`
+	Errors         int64 `json:"errors"`
+	TotalBytes     int64 `json:"totalBytes"`
+	Transfers      int64 `json:"transfers"`
+	TotalTransfers int64 `json:"totalTransfers"`
+}
+
+// rcloneJob starts an async rclone rc call and polls it until it finishes,
+// calling progress with the stats of its group.
+func rcloneJob(ctx context.Context, method string, req any, group string, progress func(rcloneStats)) (rcloneStats, error) {
+	var (
+		job   struct{ JobID int64 }
+		stats rcloneStats
+	)
+
+	reqJSON, err := json.Marshal(req)
+	if err != nil {
+		return stats, err
+	}
+
+	res, status := rcloneAction(method, string(reqJSON))
+	if status != 200 {
+		return stats, rcloneError(method, status, res)
+	}
+
+	if err := json.Unmarshal([]byte(res), &job); err != nil {
+		return stats, fmt.Errorf("json unmarshal %s job id: %w", method, err)
+	}
+
+	statusReq := fmt.Sprintf(`{"jobid": %d}`, job.JobID)
+	statsReq := fmt.Sprintf(`{"group": %q}`, group)
+
+	for {
+		select {
+		case <-ctx.Done():
+			rcloneAction("job/stop", statusReq)
+
+			return stats, ctx.Err()
+		case <-time.After(rclonePollInterval):
 		}
 
-		requestJSON, err = json.Marshal(purgeReq)
-		if err != nil {
-			logger.Error("json marshal rclone purge/delete request: " + err.Error())
+		if res, status := rcloneAction("core/stats", statsReq); status == 200 {
+			_ = json.Unmarshal([]byte(res), &stats)
 		}
 
-		msg := fmt.Sprintf("(%d/%d) deleting objects in bucket %s", idx, len(buckets), bucket)
-		logger.Info(msg)
+		var st struct {
+			Finished bool   `json:"finished"`
+			Success  bool   `json:"success"`
+			Error    string `json:"error"`
+		}
 
-		res, status = rcloneAction(method, string(requestJSON))
+		res, status := rcloneAction("job/status", statusReq)
 		if status != 200 {
-			msg := fmt.Sprintf("delete bucket operation status %v, response: %v", status, res)
-			logger.Error(msg)
+			return stats, rcloneError("job/status", status, res)
+		}
+
+		if err := json.Unmarshal([]byte(res), &st); err != nil {
+			return stats, fmt.Errorf("json unmarshal job status: %w", err)
+		}
+
+		progress(stats)
+
+		if st.Finished {
+			if !st.Success {
+				return stats, fmt.Errorf("%s job %d: %s", method, job.JobID, rcloneSecret.ReplaceAllString(st.Error, "$1=***"))
+			}
+
+			return stats, nil
 		}
 	}
 }
 
+// rcloneAction calls an rc method. librclone is initialized once, since
+// async jobs and their stats live in the library between calls.
 func rcloneAction(method, req string) (string, int) {
-	librclone.Initialize()
-	defer librclone.Finalize()
+	rcloneInit.Do(librclone.Initialize)
 
 	res, status := librclone.RPC(method, req)
 
@@ -0,0 +1,680 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/linode/linodego"
+	"github.com/pulumi/pulumi/sdk/v3/go/auto"
+	"github.com/pulumi/pulumi/sdk/v3/go/auto/optpreview"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"gopkg.in/yaml.v2"
+	yamlv3 "gopkg.in/yaml.v3"
+)
+
+const aplChartIndex = "https://linode.github.io/apl-core/index.yaml"
+
+var (
+	upgradeApl  string
+	upgradeKube string
+)
+
+// helmIndex is the subset of a helm repository index.yaml needed to look up
+// the kubernetes version constraint of a chart release.
+type helmIndex struct {
+	Entries map[string][]struct {
+		KubeVersion string `yaml:"kubeVersion"`
+		Version     string `yaml:"version"`
+	} `yaml:"entries"`
+}
+
+type colorPreview struct{}
+
+func (colorPreview) ApplyOption(opts *optpreview.Options) {
+	opts.Color = "always"
+}
+
+var upgradeCmd = &cobra.Command{
+	Use:   "upgrade",
+	Short: "Upgrade App Platform and Kubernetes versions",
+	PreRun: func(cmd *cobra.Command, args []string) {
+		if ok := platformStore().Exists(); !ok {
+			logger.Error("secret store not found: run 'create' command first")
+			os.Exit(0)
+		}
+
+		if upgradeApl == "" && upgradeKube == "" {
+			logger.Error("upgrade: one of --apl-version or --kube-version is required")
+			os.Exit(1)
+		}
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := context.Background()
+		stacks := StackMap{
+			1: {Name: "infra", PostRun: []string{"addNodeBalancerId"}},
+			2: {Name: "apl"},
+		}
+
+		for _, i := range stacks {
+			i.Path = filepath.Join(paths.Projects, platform.Name, "cmd", i.Name)
+			i.GetFullName(ctx)
+		}
+
+		infra := initLocalStack(ctx, stacks[1])
+		apl := initLocalStack(ctx, stacks[2])
+
+		// current versions: stack config takes precedence over generated defaults
+		curKube := stackVersion(ctx, infra, "kubeVersion", platform.KubeVersion, defaultKubeVersion)
+		curApl := stackVersion(ctx, apl, "aplVersion", platform.AplVersion, defaultAplVersion)
+
+		wantKube := curKube
+		if upgradeKube != "" {
+			wantKube = upgradeKube
+		}
+
+		wantApl := curApl
+		if upgradeApl != "" {
+			wantApl = upgradeApl
+		}
+
+		if wantKube == curKube && wantApl == curApl {
+			logger.Info("platform is already at the requested versions")
+
+			return
+		}
+
+		msg := fmt.Sprintf("upgrade kubernetes %s -> %s, app platform %s -> %s", curKube, wantKube, curApl, wantApl)
+		logger.Info(msg)
+
+		if err := upgradeCompatible(ctx, curKube, wantKube, curApl, wantApl); err != nil {
+			logger.Error("upgrade compatibility check: " + err.Error())
+
+			return
+		}
+
+		// stage new versions in stack config and preview the changes
+		staged := map[string]string{"infra": curKube, "apl": curApl}
+		restore := func() {
+			setStackVersion(ctx, infra, "kubeVersion", staged["infra"])
+			setStackVersion(ctx, apl, "aplVersion", staged["apl"])
+		}
+
+		setStackVersion(ctx, infra, "kubeVersion", wantKube)
+		setStackVersion(ctx, apl, "aplVersion", wantApl)
+
+		for _, s := range []auto.Stack{infra, apl} {
+			msg := fmt.Sprintf("previewing %s stack", s.Name())
+			logger.Info(msg)
+
+			stdout := optpreview.ProgressStreams(os.Stdout)
+			if _, err := s.Preview(ctx, stdout, optpreview.Diff(), colorPreview{}); err != nil {
+				logger.Error("failed to preview stack: " + err.Error())
+				restore()
+
+				return
+			}
+		}
+
+		prompt := "apply upgrade? (type YES to confirm)"
+		if ok := InputPrompt("warn", "YES", prompt); !ok {
+			logger.Warn("upgrade cancelled: restoring stack config")
+			restore()
+
+			return
+		}
+
+		// safe order: control plane, then node pools, then chart. The platform
+		// config follows each stack that was upgraded.
+		if wantKube != curKube {
+			stacks[1].PostRun = append(stacks[1].PostRun, "recycleNodePools")
+			stacks[1].Up(ctx)
+
+			if !stackUpSucceeded(ctx, infra) {
+				logger.Error("kubernetes upgrade failed: app platform not upgraded")
+
+				return
+			}
+
+			setPlatformVersion("kubeVersion", wantKube)
+		}
+
+		if wantApl != curApl {
+			stacks[2].Up(ctx)
+
+			if !stackUpSucceeded(ctx, apl) {
+				logger.Error("app platform upgrade failed")
+
+				return
+			}
+
+			setPlatformVersion("aplVersion", wantApl)
+		}
+	},
+}
+
+func init() {
+	// required flags
+	upgradeCmd.Flags().StringVarP(&platform.Name, "name", "n", "", "APL instance name (required)")
+	upgradeCmd.MarkFlagRequired("name") //nolint:errcheck
+	// optional flags
+	upgradeCmd.Flags().StringVarP(&upgradeApl, "apl-version", "", "", "Target App Platform version")
+	upgradeCmd.Flags().StringVarP(&upgradeKube, "kube-version", "", "", "Target Kubernetes version")
+
+	_ = viper.BindPFlags(upgradeCmd.LocalFlags())
+}
+
+// stackVersion returns a version pinned in stack config, falling back to the
+// platform config and finally the create command defaults.
+func stackVersion(ctx context.Context, s auto.Stack, key, cfg, def string) string {
+	v, err := s.GetConfig(ctx, key)
+	if err == nil && v.Value != "" {
+		return v.Value
+	}
+
+	if cfg != "" {
+		return cfg
+	}
+
+	return def
+}
+
+func setStackVersion(ctx context.Context, s auto.Stack, key, v string) {
+	if err := s.SetConfig(ctx, key, auto.ConfigValue{Value: v}); err != nil {
+		msg := fmt.Sprintf("failed to set %s in %s stack config: %s", key, s.Name(), err.Error())
+		logger.Error(msg)
+	}
+}
+
+// stackUpSucceeded reports whether the last update of a stack succeeded.
+func stackUpSucceeded(ctx context.Context, s auto.Stack) bool {
+	history, err := s.History(ctx, 1, 1)
+	if err != nil {
+		logger.Error("read " + s.Name() + " stack history: " + err.Error())
+
+		return false
+	}
+
+	return len(history) > 0 && history[0].Result == "succeeded"
+}
+
+// setPlatformVersion writes an upgraded version to the definition of the
+// platform in config.yaml, so later deploys do not roll it back.
+func setPlatformVersion(key, v string) {
+	if err := setPlatformConfig(key, v); err != nil {
+		msg := fmt.Sprintf("failed to set %s in platform config: %s: set it to %s", key, err.Error(), v)
+		logger.Error(msg)
+
+		return
+	}
+
+	msg := fmt.Sprintf("%s set to %s in platform config", key, v)
+	logger.Info(msg)
+}
+
+// setPlatformConfig sets a key of the running platform definition in the
+// config file, keeping comments, anchors and other platforms as they are.
+func setPlatformConfig(key, v string) error {
+	file := viper.ConfigFileUsed()
+	if file == "" {
+		return errors.New("no config file in use")
+	}
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+
+	var doc yamlv3.Node
+	if err := yamlv3.Unmarshal(data, &doc); err != nil {
+		return err
+	}
+
+	var platforms *yamlv3.Node
+	if len(doc.Content) > 0 {
+		platforms = yamlMapValue(doc.Content[0], "platform")
+	}
+
+	if platforms == nil || platforms.Kind != yamlv3.SequenceNode {
+		return errors.New("no platform list in " + file)
+	}
+
+	for _, p := range platforms.Content {
+		if name := yamlMapValue(p, "name"); name == nil || name.Value != platform.Name {
+			continue
+		}
+
+		value := &yamlv3.Node{Kind: yamlv3.ScalarNode, Tag: "!!str", Value: v}
+		if node := yamlMapValue(p, key); node != nil {
+			*node = *value
+		} else {
+			p.Content = append(p.Content, &yamlv3.Node{Kind: yamlv3.ScalarNode, Value: key}, value)
+		}
+
+		buf := &bytes.Buffer{}
+		enc := yamlv3.NewEncoder(buf)
+		enc.SetIndent(2)
+
+		if err := enc.Encode(&doc); err != nil {
+			return err
+		}
+
+		if err := enc.Close(); err != nil {
+			return err
+		}
+
+		return os.WriteFile(file, buf.Bytes(), 0600)
+	}
+
+	return fmt.Errorf("platform %s not found in %s", platform.Name, file)
+}
+
+// yamlMapValue returns the value of a key in a yaml mapping node. Keys match
+// case-insensitively, the way viper reads them.
+func yamlMapValue(m *yamlv3.Node, key string) *yamlv3.Node {
+	if m == nil || m.Kind != yamlv3.MappingNode {
+		return nil
+	}
+
+	for i := 0; i+1 < len(m.Content); i += 2 {
+		if strings.EqualFold(m.Content[i].Value, key) {
+			return m.Content[i+1]
+		}
+	}
+
+	return nil
+}
+
+// upgradeCompatible rejects downgrades, skipped kubernetes minor versions,
+// kubernetes versions LKE does not offer, and APL releases whose chart does
+// not support the target kubernetes version.
+func upgradeCompatible(ctx context.Context, curKube, wantKube, curApl, wantApl string) error {
+	if compareVersions(wantKube, curKube) < 0 {
+		return fmt.Errorf("kubernetes downgrade from %s to %s is not supported", curKube, wantKube)
+	}
+
+	if compareVersions(wantApl, curApl) < 0 {
+		return fmt.Errorf("app platform downgrade from %s to %s is not supported", curApl, wantApl)
+	}
+
+	cur, want := parseVersion(curKube), parseVersion(wantKube)
+	if len(cur) > 1 && len(want) > 1 && want[0] == cur[0] && want[1]-cur[1] > 1 {
+		return fmt.Errorf("kubernetes minor versions cannot be skipped (%s -> %s)", curKube, wantKube)
+	}
+
+	if wantKube != curKube {
+		client := NewLinodeClient()
+
+		versions, err := client.ListLKEVersions(ctx, &linodego.ListOptions{})
+		if err != nil {
+			return errors.New("list lke versions: " + err.Error())
+		}
+
+		offered := false
+
+		for _, i := range versions {
+			if i.ID == wantKube {
+				offered = true
+			}
+		}
+
+		if !offered {
+			return fmt.Errorf("kubernetes %s is not offered by LKE", wantKube)
+		}
+	}
+
+	constraint, err := aplKubeConstraint(ctx, wantApl)
+	if err != nil {
+		return err
+	}
+
+	ok, err := satisfiesConstraint(wantKube, constraint)
+	if err != nil {
+		return errors.New("app platform " + wantApl + " kubeVersion: " + err.Error())
+	}
+
+	if !ok {
+		return fmt.Errorf("app platform %s requires kubernetes %s", wantApl, constraint)
+	}
+
+	return nil
+}
+
+// aplKubeConstraint returns the kubeVersion constraint of an APL chart release
+// from the apl-core helm repository index.
+func aplKubeConstraint(ctx context.Context, version string) (string, error) {
+	var index helmIndex
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, aplChartIndex, nil)
+	if err != nil {
+		return "", err
+	}
+
+	httpClient := &http.Client{
+		Timeout: time.Second * 30,
+	}
+
+	res, err := httpClient.Do(req)
+	if err != nil {
+		return "", errors.New("fetch apl chart index: " + err.Error())
+	}
+
+	defer res.Body.Close()
+
+	if err := yaml.NewDecoder(res.Body).Decode(&index); err != nil {
+		return "", errors.New("decode apl chart index: " + err.Error())
+	}
+
+	for _, i := range index.Entries["apl"] {
+		if i.Version == version {
+			return i.KubeVersion, nil
+		}
+	}
+
+	return "", fmt.Errorf("app platform chart version %s not found", version)
+}
+
+// constraintOps are the comparison operators of helm style constraints, longest
+// first so that >= is not read as >.
+var constraintOps = []string{"!=", ">=", "=>", "<=", "=<", "~>", ">", "<", "=", "~", "^"}
+
+// satisfiesConstraint checks a version against a helm style constraint, e.g.
+// ">=1.30.0-0 <1.34.0-0". Comparisons separated by spaces or commas must all
+// hold and || separates alternatives. It supports the operators of
+// constraintOps, x wildcards and hyphen ranges, and fails on anything else.
+func satisfiesConstraint(version, constraint string) (bool, error) {
+	for _, group := range strings.Split(constraint, "||") {
+		fields := strings.FieldsFunc(group, func(r rune) bool {
+			return r == ' ' || r == ','
+		})
+
+		ok := len(fields) > 0
+
+		for i := 0; i < len(fields); i++ {
+			var (
+				hold bool
+				err  error
+			)
+
+			switch {
+			case i+2 < len(fields) && fields[i+1] == "-":
+				// hyphen range, e.g. 1.30 - 1.33
+				if hold, err = checkConstraint(version, ">="+fields[i]); err == nil && hold {
+					hold, err = checkConstraint(version, "<="+fields[i+2])
+				}
+
+				i += 2
+			case slices.Contains(constraintOps, fields[i]) && i+1 < len(fields):
+				// operator separated from its version, e.g. ">= 1.30"
+				hold, err = checkConstraint(version, fields[i]+fields[i+1])
+				i++
+			default:
+				hold, err = checkConstraint(version, fields[i])
+			}
+
+			if err != nil {
+				return false, err
+			}
+
+			ok = ok && hold
+		}
+
+		if ok {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// checkConstraint checks a version against a single comparison. A partial or
+// wildcard version such as 1.31.x matches every version it covers, ~ allows
+// patch releases (minor releases when only the major is given) and ^ allows
+// releases that keep the left-most non-zero component.
+func checkConstraint(version, c string) (bool, error) {
+	op := ""
+
+	for _, i := range constraintOps {
+		if strings.HasPrefix(c, i) {
+			op = i
+
+			break
+		}
+	}
+
+	parts, wild, err := constraintVersion(strings.TrimPrefix(c, op))
+	if err != nil {
+		return false, fmt.Errorf("constraint %q: %w", c, err)
+	}
+
+	// a bare wildcard matches any version
+	if wild && len(parts) == 0 {
+		return op != "!=" && op != ">" && op != "<", nil
+	}
+
+	lo := joinVersion(parts)
+	atLeast := compareVersions(version, lo) >= 0
+	below := func(v []int) bool {
+		return compareVersions(version, joinVersion(v)) < 0
+	}
+
+	switch op {
+	case "", "=":
+		if wild {
+			return atLeast && below(bumpVersion(parts, len(parts)-1)), nil
+		}
+
+		return compareVersions(version, lo) == 0, nil
+	case "!=":
+		if wild {
+			return !atLeast || !below(bumpVersion(parts, len(parts)-1)), nil
+		}
+
+		return compareVersions(version, lo) != 0, nil
+	case ">=", "=>":
+		return atLeast, nil
+	case ">":
+		if wild {
+			return !below(bumpVersion(parts, len(parts)-1)), nil
+		}
+
+		return compareVersions(version, lo) > 0, nil
+	case "<=", "=<":
+		if wild {
+			return below(bumpVersion(parts, len(parts)-1)), nil
+		}
+
+		return compareVersions(version, lo) <= 0, nil
+	case "<":
+		return !atLeast, nil
+	case "~", "~>":
+		return atLeast && below(bumpVersion(parts, min(1, len(parts)-1))), nil
+	case "^":
+		idx := slices.IndexFunc(parts, func(n int) bool { return n != 0 })
+		if idx < 0 {
+			idx = len(parts) - 1
+		}
+
+		return atLeast && below(bumpVersion(parts, idx)), nil
+	}
+
+	return false, fmt.Errorf("constraint %q: unsupported operator", c)
+}
+
+// constraintVersion parses the version of a comparison up to its first x or *
+// wildcard, ignoring a leading "v" and any pre-release suffix.
+func constraintVersion(v string) ([]int, bool, error) {
+	v = strings.TrimPrefix(strings.TrimSpace(v), "v")
+	v, _, _ = strings.Cut(v, "-")
+
+	if v == "" {
+		return nil, false, errors.New("missing version")
+	}
+
+	nums := make([]int, 0, 3)
+
+	for _, i := range strings.Split(v, ".") {
+		if i == "x" || i == "X" || i == "*" {
+			return nums, true, nil
+		}
+
+		n, err := strconv.Atoi(i)
+		if err != nil {
+			return nil, false, fmt.Errorf("invalid version %q", v)
+		}
+
+		nums = append(nums, n)
+	}
+
+	return nums, false, nil
+}
+
+// bumpVersion returns the version with the component at idx incremented and
+// the ones after it dropped, the exclusive upper bound of a range.
+func bumpVersion(parts []int, idx int) []int {
+	next := slices.Clone(parts[:idx+1])
+	next[idx]++
+
+	return next
+}
+
+func joinVersion(parts []int) string {
+	s := make([]string, len(parts))
+	for idx, i := range parts {
+		s[idx] = strconv.Itoa(i)
+	}
+
+	return strings.Join(s, ".")
+}
+
+// compareVersions compares dotted versions numerically, ignoring a leading "v"
+// and any pre-release suffix. Missing components compare as zero.
+func compareVersions(a, b string) int {
+	va, vb := parseVersion(a), parseVersion(b)
+
+	for i := range max(len(va), len(vb)) {
+		var x, y int
+
+		if i < len(va) {
+			x = va[i]
+		}
+
+		if i < len(vb) {
+			y = vb[i]
+		}
+
+		if x != y {
+			if x < y {
+				return -1
+			}
+
+			return 1
+		}
+	}
+
+	return 0
+}
+
+func parseVersion(v string) []int {
+	v = strings.TrimPrefix(strings.TrimSpace(v), "v")
+	v, _, _ = strings.Cut(v, "-")
+
+	nums := make([]int, 0, 3)
+
+	for _, i := range strings.Split(v, ".") {
+		n, err := strconv.Atoi(i)
+		if err != nil {
+			break
+		}
+
+		nums = append(nums, n)
+	}
+
+	return nums
+}
+
+// recycleNodePools recycles each LKE node pool in turn so that nodes pick up
+// the control plane's kubernetes version, waiting for every node to report
+// ready before moving on to the next pool.
+func recycleNodePools(ctx context.Context, s auto.Stack) {
+	_, lkeId := getResourceVar(ctx, "lkeId", s)
+	client := NewLinodeClient()
+
+	if _, err := client.WaitForLKEClusterStatus(ctx, lkeId, linodego.LKEClusterReady, 600); err != nil {
+		logger.Error("wait for lke control plane: " + err.Error())
+
+		return
+	}
+
+	pools, err := client.ListLKENodePools(ctx, lkeId, &linodego.ListOptions{})
+	if err != nil {
+		logger.Error("list lke node pools: " + err.Error())
+
+		return
+	}
+
+	for idx, pool := range pools {
+		idx++
+		msg := fmt.Sprintf("(%d/%d) recycling node pool %d", idx, len(pools), pool.ID)
+		logger.Info(msg)
+
+		// node ids change on recycle, so track the ones being replaced
+		replaced := make(map[string]bool)
+		for _, i := range pool.Linodes {
+			replaced[i.ID] = true
+		}
+
+		if err := client.RecycleLKENodePool(ctx, lkeId, pool.ID); err != nil {
+			logger.Error("recycle lke node pool: " + err.Error())
+
+			return
+		}
+
+		if err := waitForNodePool(ctx, client, lkeId, pool.ID, replaced); err != nil {
+			logger.Error("wait for lke node pool: " + err.Error())
+
+			return
+		}
+	}
+}
+
+func waitForNodePool(ctx context.Context, client linodego.Client, lkeId, poolId int, replaced map[string]bool) error {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Minute)
+	defer cancel()
+
+	ticker := time.NewTicker(15 * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			pool, err := client.GetLKENodePool(ctx, lkeId, poolId)
+			if err != nil {
+				return err
+			}
+
+			ready := len(pool.Linodes) >= pool.Count
+
+			for _, i := range pool.Linodes {
+				if replaced[i.ID] || i.Status != linodego.LKELinodeReady {
+					ready = false
+				}
+			}
+
+			if ready {
+				return nil
+			}
+		case <-ctx.Done():
+			return fmt.Errorf("node pool %d not ready: %w", poolId, ctx.Err())
+		}
+	}
+}